@@ -0,0 +1,595 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/itepastra/flutties/helpers"
+	"github.com/itepastra/flutties/helpers/access"
+	"github.com/itepastra/flutties/helpers/config"
+	"github.com/itepastra/flutties/types"
+)
+
+var (
+	admin_token = flag.String("admin_token", "", "the bearer token for the admin api, the api is disabled when empty")
+)
+
+var (
+	bans = access.NewBans()
+)
+
+type banRequest struct {
+	Prefix   string `json:"prefix"`
+	Duration string `json:"duration"`
+}
+
+type rectRequest struct {
+	types.Rect
+	Color string `json:"color"`
+}
+
+type resizeRequest struct {
+	Width  int    `json:"w"`
+	Height int    `json:"h"`
+	Anchor string `json:"anchor"`
+	Color  string `json:"color"`
+}
+
+type regionsResponse struct {
+	Regions  []types.Rect `json:"regions"`
+	Rejected uint64       `json:"rejected"`
+}
+
+type layersResponse struct {
+	Layers     []types.LayerInfo `json:"layers"`
+	WriteLayer string            `json:"write_layer"`
+}
+
+// layerUpdate changes the fields of a layer that are given.
+type layerUpdate struct {
+	Opacity *uint8 `json:"opacity"`
+	Visible *bool  `json:"visible"`
+}
+
+type zonesResponse struct {
+	Zones     []types.Zone `json:"zones"`
+	Exclusive bool         `json:"exclusive"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// adminOnly only lets requests through that carry the admin token.
+func adminOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if conf.AdminToken == "" || !found || subtle.ConstantTimeCompare([]byte(token), []byte(conf.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// banned reports whether the remote address of a connection or request is
+// banned. Addresses that can't be parsed are treated as banned.
+func banned(remote string) bool {
+	addr, err := access.RemoteAddr(remote)
+	if err != nil {
+		return true
+	}
+	return bans.Banned(addr)
+}
+
+func canvasFromPath(r *http.Request, grids [types.GRID_AMOUNT]*types.Grid) (*types.Grid, error) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 8)
+	if err != nil || id >= types.GRID_AMOUNT {
+		return nil, errors.New("unknown canvas")
+	}
+	return grids[id], nil
+}
+
+func fillHandler(grids [types.GRID_AMOUNT]*types.Grid, clear bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		req := rectRequest{Color: "000000"}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if clear {
+			req.Color = "000000"
+		}
+		color, err := parseColor(req.Color)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		count := grid.Fill(req.Rectangle(), color)
+		writeJSON(w, http.StatusOK, map[string]int{"pixels": count})
+	}
+}
+
+// textHandler writes text on a canvas as the server, like fill it ignores
+// protected regions and frozen canvasses.
+func textHandler(grids [types.GRID_AMOUNT]*types.Grid, backgrounds [types.GRID_AMOUNT]*background) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		var req drawRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		drawRequestOn(w, grid, backgrounds[grid.Index], shapes["text"], req, nil)
+	}
+}
+
+// registerOverlays adds the endpoints to list, schedule and remove the
+// overlays of a canvas.
+func registerOverlays(grids [types.GRID_AMOUNT]*types.Grid, scheduled [types.GRID_AMOUNT]*overlays) {
+	http.HandleFunc("GET /admin/canvas/{id}/overlays", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, scheduled[grid.Index].List())
+	}))
+	http.HandleFunc("POST /admin/canvas/{id}/overlays", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		var overlay config.Overlay
+		if err := json.NewDecoder(r.Body).Decode(&overlay); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := scheduled[grid.Index].Add(overlay); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, scheduled[grid.Index].List())
+	}))
+	http.HandleFunc("DELETE /admin/canvas/{id}/overlays/{index}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		index, err := strconv.Atoi(r.PathValue("index"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if !scheduled[grid.Index].Remove(index) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no overlay at index %d", index))
+			return
+		}
+		writeJSON(w, http.StatusOK, scheduled[grid.Index].List())
+	}))
+}
+
+func layerFromPath(r *http.Request, grids [types.GRID_AMOUNT]*types.Grid) (*types.Layer, error) {
+	grid, err := canvasFromPath(r, grids)
+	if err != nil {
+		return nil, err
+	}
+	return grid.Layer(r.PathValue("name"))
+}
+
+// layerFillHandler fills a rectangle of a layer, clearing makes the pixels
+// transparent again.
+func layerFillHandler(grids [types.GRID_AMOUNT]*types.Grid, clear bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		layer, err := layerFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		req := rectRequest{Color: "000000"}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		color, err := parseColor(req.Color)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if clear {
+			color = 0
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"pixels": layer.Fill(req.Rectangle(), color)})
+	}
+}
+
+// registerLayers adds the endpoints to manage the layers of a canvas and to
+// choose the layer clients write to.
+func registerLayers(grids [types.GRID_AMOUNT]*types.Grid) {
+	layers := func(grid *types.Grid) layersResponse {
+		return layersResponse{grid.Layers(), grid.WriteLayer()}
+	}
+	http.HandleFunc("GET /admin/canvas/{id}/layers", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, layers(grid))
+	}))
+	http.HandleFunc("POST /admin/canvas/{id}/layers", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		req := types.LayerInfo{Opacity: 0xff, Visible: true}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if _, err := grid.AddLayer(req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, layers(grid))
+	}))
+	http.HandleFunc("POST /admin/canvas/{id}/layers/{name}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		layer, err := layerFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		var req layerUpdate
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Opacity != nil {
+			layer.SetOpacity(*req.Opacity)
+		}
+		if req.Visible != nil {
+			layer.SetVisible(*req.Visible)
+		}
+		writeJSON(w, http.StatusOK, layer.Info())
+	}))
+	http.HandleFunc("DELETE /admin/canvas/{id}/layers/{name}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err := grid.RemoveLayer(r.PathValue("name")); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, layers(grid))
+	}))
+	http.HandleFunc("POST /admin/canvas/{id}/layers/{name}/fill", adminOnly(layerFillHandler(grids, false)))
+	http.HandleFunc("POST /admin/canvas/{id}/layers/{name}/clear", adminOnly(layerFillHandler(grids, true)))
+	http.HandleFunc("POST /admin/canvas/{id}/layers/{name}/image", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		layer, err := layerFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_UPLOAD_SIZE)
+		img, err := uploadImage(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		x, err := formInt(r, "x", 0)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		y, err := formInt(r, "y", 0)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"pixels": layer.Draw(img, image.Pt(x, y))})
+	}))
+	http.HandleFunc("POST /admin/canvas/{id}/write_layer", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := grid.SetWriteLayer(req.Name); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, layers(grid))
+	}))
+	http.HandleFunc("GET /admin/canvas/{id}/snapshot", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		png.Encode(w, grid)
+	}))
+}
+
+func resizeHandler(grids [types.GRID_AMOUNT]*types.Grid) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		req := resizeRequest{Anchor: "nw", Color: "000000"}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Width < 1 || req.Width > 0xffff || req.Height < 1 || req.Height > 0xffff {
+			writeError(w, http.StatusBadRequest, errors.New("the size should be between 1 and 65535"))
+			return
+		}
+		anchor, err := types.ParseAnchor(req.Anchor)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		color, err := parseColor(req.Color)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		grid.Resize(uint16(req.Width), uint16(req.Height), anchor, color)
+		helpers.NotifyResize(clients, grid)
+		sizeX, sizeY := grid.Size()
+		writeJSON(w, http.StatusOK, map[string]int{"w": sizeX, "h": sizeY})
+	}
+}
+
+func freezeHandler(grids [types.GRID_AMOUNT]*types.Grid, frozen bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		grid.Freeze(frozen)
+		writeJSON(w, http.StatusOK, map[string]bool{"frozen": grid.Frozen()})
+	}
+}
+
+type editableList[T any] interface {
+	List() []T
+	Add(items ...T)
+	Remove(i int) bool
+}
+
+// registerListEdits adds the endpoints to add to and remove from a list that
+// every grid has.
+func registerListEdits[T any](grids [types.GRID_AMOUNT]*types.Grid, name string, get func(*types.Grid) editableList[T]) {
+	http.HandleFunc("POST /admin/canvas/{id}/"+name, adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		var item T
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		list := get(grid)
+		list.Add(item)
+		writeJSON(w, http.StatusCreated, list.List())
+	}))
+	http.HandleFunc("DELETE /admin/canvas/{id}/"+name+"/{index}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		index, err := strconv.Atoi(r.PathValue("index"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		list := get(grid)
+		if !list.Remove(index) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no %s at index %d", name, index))
+			return
+		}
+		writeJSON(w, http.StatusOK, list.List())
+	}))
+}
+
+// queryInt parses the query parameter name as an int, fallback is used when
+// it isn't given.
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return n, nil
+}
+
+func ownersHandler(grids [types.GRID_AMOUNT]*types.Grid) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		rect := types.Rect{}
+		for _, param := range []struct {
+			name     string
+			value    *int
+			fallback int
+		}{{"x", &rect.X, 0}, {"y", &rect.Y, 0}, {"w", &rect.W, 1}, {"h", &rect.H, 1}} {
+			if *param.value, err = queryInt(r, param.name, param.fallback); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		area := rect.Rectangle().Intersect(grid.Bounds())
+		if area.Empty() {
+			writeError(w, http.StatusBadRequest, errors.New("the area is outside of the canvas"))
+			return
+		}
+		writeJSON(w, http.StatusOK, grid.Lookup(area))
+	}
+}
+
+func heatmapHandler(grids [types.GRID_AMOUNT]*types.Grid) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		minutes, err := queryInt(r, "minutes", 10)
+		if err != nil || minutes <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("minutes should be a positive number"))
+			return
+		}
+		byOwner := r.URL.Query().Get("mode") == "owner"
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		png.Encode(w, grid.Heatmap(time.Duration(minutes)*time.Minute, byOwner))
+	}
+}
+
+func registerAdmin(grids [types.GRID_AMOUNT]*types.Grid, backgrounds [types.GRID_AMOUNT]*background, scheduled [types.GRID_AMOUNT]*overlays) {
+	http.HandleFunc("GET /admin/clients", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, clients.Stats())
+	}))
+	http.HandleFunc("DELETE /admin/clients/{id}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		client, found := clients.Get(id)
+		if !found {
+			writeError(w, http.StatusNotFound, errors.New("unknown client"))
+			return
+		}
+		client.Close()
+		writeJSON(w, http.StatusOK, client.Stats())
+	}))
+
+	http.HandleFunc("GET /admin/bans", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, bans.List())
+	}))
+	http.HandleFunc("POST /admin/bans", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		req := banRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		prefix, err := access.ParsePrefix(req.Prefix)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		var duration time.Duration
+		if req.Duration != "" {
+			duration, err = time.ParseDuration(req.Duration)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		ban := bans.Add(prefix, duration)
+		// kick everyone that is already connected from the banned prefix
+		for _, client := range clients.Clients() {
+			if addr, err := access.RemoteAddr(client.Addr); err == nil && prefix.Contains(addr) {
+				client.Close()
+			}
+		}
+		writeJSON(w, http.StatusCreated, ban)
+	}))
+	http.HandleFunc("DELETE /admin/bans", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		prefix, err := access.ParsePrefix(r.URL.Query().Get("prefix"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if !bans.Remove(prefix) {
+			writeError(w, http.StatusNotFound, errors.New("prefix is not banned"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	http.HandleFunc("POST /admin/canvas/{id}/fill", adminOnly(fillHandler(grids, false)))
+	http.HandleFunc("POST /admin/canvas/{id}/clear", adminOnly(fillHandler(grids, true)))
+	http.HandleFunc("POST /admin/canvas/{id}/text", adminOnly(textHandler(grids, backgrounds)))
+	http.HandleFunc("GET /admin/canvas/{id}/regions", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, regionsResponse{grid.Protected.List(), atomic.LoadUint64(&grid.Rejected)})
+	}))
+	registerListEdits(grids, "regions", func(grid *types.Grid) editableList[types.Rect] { return grid.Protected })
+	http.HandleFunc("GET /admin/canvas/{id}/zones", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, zonesResponse{grid.Zones.List(), grid.Zones.Exclusive()})
+	}))
+	registerListEdits(grids, "zones", func(grid *types.Grid) editableList[types.Zone] { return grid.Zones })
+	registerOverlays(grids, scheduled)
+	registerLayers(grids)
+
+	http.HandleFunc("GET /admin/canvas/{id}/owners", adminOnly(ownersHandler(grids)))
+	http.HandleFunc("GET /admin/canvas/{id}/heatmap", adminOnly(heatmapHandler(grids)))
+
+	http.HandleFunc("POST /admin/canvas/{id}/resize", adminOnly(resizeHandler(grids)))
+	http.HandleFunc("POST /admin/canvas/{id}/freeze", adminOnly(freezeHandler(grids, true)))
+	http.HandleFunc("POST /admin/canvas/{id}/unfreeze", adminOnly(freezeHandler(grids, false)))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/itepastra/flutties/helpers/access"
+	"github.com/itepastra/flutties/helpers/draw"
+	"github.com/itepastra/flutties/types"
+)
+
+// drawRequest is the body of the drawing api. The shapes use the fields they
+// need, size is the radius of circles and the width of lines.
+type drawRequest struct {
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	X2    int    `json:"x2"`
+	Y2    int    `json:"y2"`
+	Size  int    `json:"size"`
+	Color string `json:"color"`
+	// Alpha is how much the color covers the canvas, opaque when missing
+	Alpha *uint8 `json:"alpha"`
+	// Erase draws the background of the canvas instead of the color
+	Erase bool   `json:"erase"`
+	Text  string `json:"text"`
+	// Scale is the size of the pixels of text
+	Scale int `json:"scale"`
+	// Background is the color behind text, without it the text has none
+	Background string `json:"background"`
+}
+
+type drawResponse struct {
+	Pixels int    `json:"pixels"`
+	Error  string `json:"error,omitempty"`
+}
+
+// shape draws req with pen, shapes with a background draw it with background
+// which can be nil.
+type shape func(pen *draw.Pen, background *draw.Pen, req drawRequest) error
+
+var shapes = map[string]shape{
+	"pixel": func(pen *draw.Pen, _ *draw.Pen, req drawRequest) error {
+		return pen.Pixel(req.X, req.Y)
+	},
+	"circle": func(pen *draw.Pen, _ *draw.Pen, req drawRequest) error {
+		return pen.Circle(req.X, req.Y, req.Size)
+	},
+	"line": func(pen *draw.Pen, _ *draw.Pen, req drawRequest) error {
+		return pen.Line(req.X, req.Y, req.X2, req.Y2, max(req.Size, 1))
+	},
+	"rect": func(pen *draw.Pen, _ *draw.Pen, req drawRequest) error {
+		return pen.Rect(req.X, req.Y, req.X2, req.Y2)
+	},
+	"fill": func(pen *draw.Pen, _ *draw.Pen, req drawRequest) error {
+		return pen.Fill(req.X, req.Y)
+	},
+	"text": func(pen *draw.Pen, background *draw.Pen, req drawRequest) error {
+		return pen.Text(req.X, req.Y, req.Text, req.Scale, background)
+	},
+}
+
+// drawStatus is the status code of a drawing that failed with err.
+func drawStatus(err error) int {
+	switch {
+	case errors.Is(err, types.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, types.ErrFrozen):
+		return http.StatusLocked
+	case errors.Is(err, types.ErrOutOfBounds):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// newRequestPens returns the pens that draw req on grid, the background pen
+// is nil without a background color.
+func newRequestPens(grid *types.Grid, bg *background, req drawRequest, client *types.Client) (pen *draw.Pen, background *draw.Pen, err error) {
+	alpha := uint32(0xff)
+	if req.Alpha != nil {
+		alpha = uint32(*req.Alpha)
+	}
+	if req.Background != "" {
+		color, err := parseColor(req.Background)
+		if err != nil {
+			return nil, nil, err
+		}
+		background = draw.NewPen(grid, color&0xffffff|alpha<<24, client)
+	}
+	if req.Erase {
+		drawn, err := bg.of(grid)
+		if err != nil {
+			return nil, nil, err
+		}
+		return draw.NewEraser(grid, drawn, byte(alpha), client), background, nil
+	}
+	color, err := parseColor(req.Color)
+	if err != nil {
+		return nil, nil, err
+	}
+	return draw.NewPen(grid, color&0xffffff|alpha<<24, client), background, nil
+}
+
+// drawRequestOn draws req with fn on grid for client and replies with the
+// amount of pixels that were drawn.
+func drawRequestOn(w http.ResponseWriter, grid *types.Grid, bg *background, fn shape, req drawRequest, client *types.Client) {
+	pen, background, err := newRequestPens(grid, bg, req, client)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	err = fn(pen, background, req)
+	pixels := pen.Pixels
+	if background != nil {
+		pixels += background.Pixels
+	}
+	if err != nil {
+		writeJSON(w, drawStatus(err), drawResponse{pixels, err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, drawResponse{Pixels: pixels})
+}
+
+func drawHandler(grids [types.GRID_AMOUNT]*types.Grid, backgrounds [types.GRID_AMOUNT]*background, webACL *access.List, fn shape) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if banned(r.RemoteAddr) || !allowed(webACL, r.RemoteAddr) {
+			writeError(w, http.StatusForbidden, errors.New("forbidden"))
+			return
+		}
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		var req drawRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		client := clients.Host(r.RemoteAddr, types.PROTOCOL_HTTP)
+		drawRequestOn(w, grid, backgrounds[grid.Index], fn, req, client)
+	}
+}
+
+// registerAPI adds the drawing api, POST /api/canvas/{id}/<shape> draws a
+// shape and POST /api/canvas/{id}/image an image for everyone on the web
+// access list.
+func registerAPI(grids [types.GRID_AMOUNT]*types.Grid, backgrounds [types.GRID_AMOUNT]*background, webACL *access.List) {
+	for name, shape := range shapes {
+		http.HandleFunc("POST /api/canvas/{id}/"+name, drawHandler(grids, backgrounds, webACL, shape))
+	}
+	http.HandleFunc("POST /api/canvas/{id}/image", uploadHandler(grids, webACL))
+	http.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, errors.New("unknown endpoint"))
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/itepastra/flutties/helpers/config"
+	"github.com/itepastra/flutties/types"
+)
+
+// newCanvas creates a grid and draws background on it.
+func newCanvas(background config.Background, sizeX uint16, sizeY uint16, canvasId byte) (*types.Grid, error) {
+	switch background.Mode {
+	case "random":
+		return types.NewGridRandom(sizeX, sizeY, canvasId), nil
+	case "image":
+		img, err := types.LoadImage(background.Image)
+		if err != nil {
+			return nil, err
+		}
+		grid := types.NewGrid(sizeX, sizeY, 0, canvasId)
+		grid.DrawScaled(img)
+		return grid, nil
+	}
+
+	color, err := parseColor(background.Color)
+	if err != nil {
+		return nil, err
+	}
+	color2, err := parseColor(background.Color2)
+	if err != nil {
+		return nil, err
+	}
+	grid := types.NewGrid(sizeX, sizeY, color, canvasId)
+	switch background.Mode {
+	case "gradient":
+		grid.Gradient(color, color2)
+	case "checkerboard":
+		grid.Checkerboard(color, color2, background.Size)
+	}
+	return grid, nil
+}
+
+// background is the background of a canvas, drawn again when the canvas gets
+// resized.
+type background struct {
+	canvas config.Background
+	grid   *types.Grid
+	lock   sync.Mutex
+}
+
+func newBackground(canvas config.Background) *background {
+	return &background{canvas: canvas}
+}
+
+// of returns the background at the size of grid.
+func (b *background) of(grid *types.Grid) (*types.Grid, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.grid == nil || b.grid.Bounds() != grid.Bounds() {
+		sizeX, sizeY := grid.Size()
+		drawn, err := newCanvas(b.canvas, uint16(sizeX), uint16(sizeY), grid.Index)
+		if err != nil {
+			return nil, err
+		}
+		b.grid = drawn
+	}
+	return b.grid, nil
+}
+
+// decayTimer slowly resets grid to its background.
+func decayTimer(grid *types.Grid, decay config.Decay, bg *background) {
+	for {
+		time.Sleep(decay.Interval.D())
+		background, err := bg.of(grid)
+		if err != nil {
+			log.Printf("could not draw the background of canvas %d: %s", grid.Index, err)
+			continue
+		}
+		switch decay.Mode {
+		case "fade":
+			grid.Fade(background, decay.Step)
+		case "wipe":
+			grid.Wipe(background)
+		case "stale":
+			grid.ClearStale(background, decay.Age.D())
+		}
+	}
+}
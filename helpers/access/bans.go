@@ -0,0 +1,112 @@
+/*
+Package access decides which remote addresses are allowed to use the server.
+*/
+package access
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ban is a single banned prefix, a zero Until means the ban never expires.
+type Ban struct {
+	Prefix netip.Prefix `json:"prefix"`
+	Until  time.Time    `json:"until"`
+}
+
+// Bans is a threadsafe set of banned prefixes that expire over time.
+type Bans struct {
+	bans map[netip.Prefix]time.Time
+	lock *sync.RWMutex
+}
+
+func NewBans() *Bans {
+	return &Bans{make(map[netip.Prefix]time.Time), &sync.RWMutex{}}
+}
+
+// Add bans the prefix for the given duration, or forever if it is 0.
+func (b *Bans) Add(prefix netip.Prefix, duration time.Duration) Ban {
+	ban := Ban{Prefix: prefix.Masked()}
+	if duration > 0 {
+		ban.Until = time.Now().Add(duration)
+	}
+	b.lock.Lock()
+	b.bans[ban.Prefix] = ban.Until
+	b.lock.Unlock()
+	return ban
+}
+
+// Remove lifts the ban on the prefix, it returns false if it wasn't banned.
+func (b *Bans) Remove(prefix netip.Prefix) bool {
+	prefix = prefix.Masked()
+	b.lock.Lock()
+	_, found := b.bans[prefix]
+	delete(b.bans, prefix)
+	b.lock.Unlock()
+	return found
+}
+
+// Banned reports whether addr is inside any of the active bans.
+func (b *Bans) Banned(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	now := time.Now()
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for prefix, until := range b.bans {
+		if prefix.Contains(addr) && (until.IsZero() || now.Before(until)) {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the active bans and forgets the expired ones.
+func (b *Bans) List() []Ban {
+	now := time.Now()
+	bans := []Ban{}
+	b.lock.Lock()
+	for prefix, until := range b.bans {
+		if !until.IsZero() && !now.Before(until) {
+			delete(b.bans, prefix)
+			continue
+		}
+		bans = append(bans, Ban{prefix, until})
+	}
+	b.lock.Unlock()
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Prefix.String() < bans[j].Prefix.String() })
+	return bans
+}
+
+// ParsePrefix parses either a CIDR prefix or a single address.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// RemoteAddr gets the address out of a host:port string like net.Conn and
+// http.Request use, or out of a bare host like the shared clients of
+// Registry.Host have.
+func RemoteAddr(hostport string) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
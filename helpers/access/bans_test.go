@@ -0,0 +1,30 @@
+package access
+
+import "testing"
+
+func TestRemoteAddr(t *testing.T) {
+	tests := []struct {
+		remote string
+		want   string
+	}{
+		{"10.0.0.1:1234", "10.0.0.1"},
+		{"[::ffff:10.0.0.1]:1234", "10.0.0.1"},
+		{"[2001:db8::1]:80", "2001:db8::1"},
+		{"10.0.0.1", "10.0.0.1"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"example.com:80", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		addr, err := RemoteAddr(test.remote)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("RemoteAddr(%q) = %s", test.remote, addr)
+			}
+			continue
+		}
+		if err != nil || addr.String() != test.want {
+			t.Errorf("RemoteAddr(%q) = %s, %v", test.remote, addr, err)
+		}
+	}
+}
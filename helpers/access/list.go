@@ -0,0 +1,95 @@
+package access
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+type rules struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// List is an allowlist/denylist of prefixes loaded from a file.
+//
+// Every line of the file is either empty, a comment starting with #, or
+// `allow <prefix>` / `deny <prefix>` where prefix is a CIDR prefix or a single
+// IPv4 or IPv6 address. Denied prefixes always win, when there are no allowed
+// prefixes everything that isn't denied is allowed.
+type List struct {
+	path  string
+	rules atomic.Pointer[rules]
+}
+
+// NewList loads the list from path, an empty path allows everything.
+func NewList(path string) (*List, error) {
+	list := &List{path: path}
+	list.rules.Store(&rules{})
+	return list, list.Reload()
+}
+
+// Reload reads the file again, the old rules are kept if that fails.
+func (l *List) Reload() error {
+	if l.path == "" {
+		return nil
+	}
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := rules{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected `allow <prefix>` or `deny <prefix>`", l.path, line)
+		}
+		prefix, err := ParsePrefix(fields[1])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", l.path, line, err)
+		}
+		switch fields[0] {
+		case "allow":
+			r.allow = append(r.allow, prefix)
+		case "deny":
+			r.deny = append(r.deny, prefix)
+		default:
+			return fmt.Errorf("%s:%d: unknown action %q", l.path, line, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	l.rules.Store(&r)
+	return nil
+}
+
+// Allowed reports whether addr may connect according to the list.
+func (l *List) Allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	r := l.rules.Load()
+	for _, prefix := range r.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(r.allow) == 0 {
+		return true
+	}
+	for _, prefix := range r.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package helpers
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/itepastra/flutties/types"
+)
+
+// The pixel formats of a BATCH frame.
+const (
+	BATCH_GRAY byte = iota
+	BATCH_RGB
+	BATCH_RGBA
+	BATCH_RGB565
+	BATCH_INDEX
+	// BATCH_ROW is a flag for the format, the records of the frame have no
+	// coordinates and are drawn next to each other from a start position.
+	BATCH_ROW byte = 0x80
+)
+
+// batchColorSizes are the sizes of the colors of the pixel formats.
+var batchColorSizes = []int{BATCH_GRAY: 1, BATCH_RGB: 3, BATCH_RGBA: 4, BATCH_RGB565: 2, BATCH_INDEX: 1}
+
+// batchColor decodes a color of format from c, with 16 bits per channel so
+// rgb565 is decoded like SET_RGB565 does.
+func batchColor(grid *types.Grid, format byte, c []byte) (uint64, error) {
+	switch format {
+	case BATCH_GRAY:
+		return types.Widen(types.RGBA(c[0], c[0], c[0], 0xff)), nil
+	case BATCH_RGB:
+		return types.Widen(types.RGBA(c[0], c[1], c[2], 0xff)), nil
+	case BATCH_RGBA:
+		return types.Widen(types.RGBA(c[0], c[1], c[2], c[3])), nil
+	case BATCH_RGB565:
+		return types.RGB565(binary.LittleEndian.Uint16(c)), nil
+	default:
+		c, err := grid.Palette.Color(int(c[0]))
+		return types.Widen(c), err
+	}
+}
+
+// BatchBin sets many pixels of one canvas at once. The frame is the command,
+// the canvas id, the pixel format and the amount of records as a little
+// endian uint16. Every record is x and y as little endian uint16 followed by
+// the color. With BATCH_ROW in the format, the header ends with the x and y
+// of the first pixel and the records are only colors, drawn to the right of
+// each other.
+func BatchBin(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid, client *types.Client) (int, []byte, error) {
+	if cmdLen(cmd, 5) {
+		return 0, nil, nil
+	}
+	canvasId, format := cmd[1], cmd[2]&^BATCH_ROW
+	row := cmd[2]&BATCH_ROW != 0
+	count := int(binary.LittleEndian.Uint16(cmd[3:]))
+	if int(format) >= len(batchColorSizes) {
+		return 5, cmd[:5], errors.New("unknown pixel format")
+	}
+	header, record := 5, 4+batchColorSizes[format]
+	if row {
+		header, record = 9, batchColorSizes[format]
+	}
+	length := header + count*record
+	if cmdLen(cmd, length) {
+		return 0, nil, nil
+	}
+	if int(canvasId) >= types.GRID_AMOUNT {
+		return length, cmd[:length], errors.New("unknown canvas")
+	}
+	grid := grids[canvasId]
+
+	pixels := make([]types.Pixel, count)
+	var start uint32
+	if row {
+		start = binary.LittleEndian.Uint32(cmd[5:])
+		if int(start&0xffff)+count > 0x10000 {
+			return length, cmd[:length], types.ErrOutOfBounds
+		}
+	}
+	for i := range pixels {
+		r := cmd[header+i*record:]
+		xy := start + uint32(i)
+		if !row {
+			xy, r = binary.LittleEndian.Uint32(r), r[4:]
+		}
+		c, err := batchColor(grid, format, r)
+		if err != nil {
+			return length, cmd[:length], err
+		}
+		pixels[i] = types.Pixel{XY: client.Translate(xy), Color: c}
+	}
+	_, err := grid.SetBatch(pixels, client)
+	return length, cmd[:length], err
+}
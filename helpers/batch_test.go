@@ -0,0 +1,116 @@
+package helpers
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/itepastra/flutties/types"
+)
+
+// batch builds a BATCH frame for canvas 0 out of its header and records.
+func batch(format byte, count int, rest ...byte) []byte {
+	return append([]byte{BATCH, 0, format, byte(count), byte(count >> 8)}, rest...)
+}
+
+func TestBatchBinFraming(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     []byte
+		advance int
+		err     error
+	}{
+		{"partial header", []byte{BATCH, 0, BATCH_RGB, 2}, 0, nil},
+		{"partial records", batch(BATCH_RGB, 2, 1, 0, 1, 0, 0xff, 0, 0, 2, 0), 0, nil},
+		{"partial row start", batch(BATCH_ROW|BATCH_GRAY, 1, 1, 0), 0, nil},
+		{"followed by another command", batch(BATCH_RGB, 2, 1, 0, 1, 0, 0xff, 0, 0, 2, 0, 2, 0, 0, 0xff, 0, SIZE), 19, nil},
+		{"row", batch(BATCH_ROW|BATCH_GRAY, 3, 1, 0, 2, 0, 0x10, 0x20, 0x30, SIZE), 12, nil},
+		{"empty", batch(BATCH_RGBA, 0, SIZE), 5, nil},
+		{"unknown format", batch(7, 1, 0, 0, 0, 0, 0), 5, errors.New("unknown pixel format")},
+		{"row past the last column", batch(BATCH_ROW|BATCH_GRAY, 3, 0xfe, 0xff, 0, 0, 1, 2, 3), 12, types.ErrOutOfBounds},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			grids := [types.GRID_AMOUNT]*types.Grid{types.NewGrid(8, 8, 0, 0)}
+			client := types.NewRegistry().Add("a", types.PROTOCOL_TCP, nil)
+			advance, token, err := BatchBin(test.cmd, grids, client)
+			if advance != test.advance || len(token) != test.advance {
+				t.Errorf("read %d bytes, not %d", advance, test.advance)
+			}
+			if (err == nil) != (test.err == nil) || err != nil && err.Error() != test.err.Error() {
+				t.Errorf("got error %v, not %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestBatchBinPixels(t *testing.T) {
+	grids := [types.GRID_AMOUNT]*types.Grid{types.NewGrid(8, 8, 0, 0)}
+	client := types.NewRegistry().Add("a", types.PROTOCOL_TCP, nil)
+	if _, _, err := BatchBin(batch(BATCH_RGB, 2, 1, 0, 1, 0, 0xff, 0, 0, 2, 0, 3, 0, 0, 0xff, 0), grids, client); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := BatchBin(batch(BATCH_ROW|BATCH_GRAY, 2, 6, 0, 7, 0, 0x10, 0x20), grids, client); err != nil {
+		t.Fatal(err)
+	}
+	want := map[[2]uint16]uint32{
+		{1, 1}: types.RGBA(0xff, 0, 0, 0xff),
+		{2, 3}: types.RGBA(0, 0xff, 0, 0xff),
+		{6, 7}: types.RGBA(0x10, 0x10, 0x10, 0xff),
+		{7, 7}: types.RGBA(0x20, 0x20, 0x20, 0xff),
+	}
+	for xy, c := range want {
+		if got, _ := grids[0].Get(xy[0], xy[1]); got != c {
+			t.Errorf("pixel %v is %#08x, not %#08x", xy, got, c)
+		}
+	}
+}
+
+// TestBatchBinOversized sends frames that are larger than what the canvas
+// and the pixel limit allow, they are read completely and ignored.
+func TestBatchBinOversized(t *testing.T) {
+	grids := [types.GRID_AMOUNT]*types.Grid{types.NewGrid(8, 8, 0, 0)}
+	registry := types.NewRegistry()
+	registry.PixelRate = 100
+	client := registry.Add("a", types.PROTOCOL_TCP, nil)
+
+	// the largest frame there is, every record sets (1, 1) to white
+	count := 0xffff
+	cmd := batch(BATCH_GRAY, count, make([]byte, 5*count)...)
+	for i := 0; i < count; i++ {
+		binary.LittleEndian.PutUint32(cmd[5+5*i:], 1<<16|1)
+		cmd[5+5*i+4] = 0xff
+	}
+	advance, _, err := BatchBin(append(cmd, SIZE), grids, client)
+	if advance != len(cmd) || !errors.Is(err, types.ErrRateLimited) {
+		t.Errorf("read %d of %d bytes with error %v", advance, len(cmd), err)
+	}
+	if c, _ := grids[0].Get(1, 1); c != types.RGBA(0, 0, 0, 0xff) {
+		t.Errorf("the ignored batch wrote %#08x", c)
+	}
+
+	// without a pixel limit it fits, but not in a frame that is cut off
+	advance, _, err = BatchBin(cmd[:len(cmd)-1], grids, types.NewRegistry().Add("b", types.PROTOCOL_TCP, nil))
+	if advance != 0 || err != nil {
+		t.Errorf("a cut off frame read %d bytes with error %v", advance, err)
+	}
+}
+
+// TestBatchBinRGB565Deep writes the same rgb565 color with BATCH and with
+// SET_RGB565 to a deep canvas, both have to store the same color.
+func TestBatchBinRGB565Deep(t *testing.T) {
+	grids := [types.GRID_AMOUNT]*types.Grid{types.NewGrid(8, 8, 0, 0)}
+	grids[0].EnableDeep()
+	client := types.NewRegistry().Add("a", types.PROTOCOL_TCP, nil)
+	if _, _, err := SetRGB565Bin([]byte{SET_RGB565, 0, 0, 0, 0, 0x21, 0x08}, grids, client); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := BatchBin(batch(BATCH_RGB565, 1, 1, 0, 0, 0, 0x21, 0x08), grids, client); err != nil {
+		t.Fatal(err)
+	}
+	single, _ := grids[0].GetRGBA64(0, 0)
+	batched, _ := grids[0].GetRGBA64(1, 0)
+	if single != batched || single != types.RGB565(0x0821) {
+		t.Errorf("SET_RGB565 stored %#016x and BATCH %#016x", single, batched)
+	}
+}
@@ -2,9 +2,12 @@ package helpers
 
 import (
 	"encoding/hex"
+
+	"github.com/itepastra/flutties/types"
 )
 
+// PxToHex formats a color as rrggbb.
 func PxToHex(color uint32) string {
-	cbytes := []byte{byte(color >> 24), byte(color >> 16), byte(color >> 8)}
-	return hex.EncodeToString(cbytes)
+	r, g, b, _ := types.Channels(color)
+	return hex.EncodeToString([]byte{r, g, b})
 }
@@ -0,0 +1,307 @@
+/*
+Package config contains the configuration of the server, which is read from
+a json file and can be overridden with flags.
+*/
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Duration is a time.Duration that is written as a string like "25ms" in json.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	*d = Duration(parsed)
+	return err
+}
+
+func (d Duration) D() time.Duration {
+	return time.Duration(d)
+}
+
+type Listeners struct {
+	// Pixelflut is the address the pixelflut server listens on
+	Pixelflut string `json:"pixelflut"`
+	// PixelflutExternal is the port the pixelflut is reachable on from the
+	// outside, it is shown on the webpage
+	PixelflutExternal string `json:"pixelflut_ext"`
+	Web               string `json:"web"`
+}
+
+// Background is how a canvas looks before anything is drawn on it.
+type Background struct {
+	// Mode is solid, random, gradient, checkerboard or image
+	Mode string `json:"mode"`
+	// Color is used by solid, and together with Color2 by gradient and
+	// checkerboard. Gradients go from Color at the top to Color2 at the
+	// bottom.
+	Color  string `json:"color"`
+	Color2 string `json:"color2"`
+	// Size is the size of the squares of a checkerboard
+	Size int `json:"size"`
+	// Image is the png, jpeg or gif file that is scaled to the canvas
+	Image string `json:"image"`
+}
+
+// Decay slowly resets a canvas to its background, it is disabled without a
+// mode.
+type Decay struct {
+	// Mode is fade, wipe or stale
+	Mode     string   `json:"mode"`
+	Interval Duration `json:"interval"`
+	// Step is how much every color channel fades every interval
+	Step int `json:"step"`
+	// Age is how long pixels have to be left alone before stale clears them
+	Age Duration `json:"age"`
+}
+
+// Overlay is text or an image that is shown over a canvas in the streams
+// from Start until End, a zero time leaves that side open. Clients can't read
+// or overwrite overlays.
+type Overlay struct {
+	// Text can contain {countdown}, the time that is left until Countdown,
+	// and {port}, the external pixelflut port
+	Text string `json:"text,omitempty"`
+	// Image is a png, jpeg or gif file that is shown instead of text
+	Image string `json:"image,omitempty"`
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	// Scale is the size of the pixels of the text or image
+	Scale int `json:"scale"`
+	// Color and Background are the colors of the text as rrggbb, without a
+	// background the text has none
+	Color      string    `json:"color"`
+	Background string    `json:"background"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Countdown  time.Time `json:"countdown"`
+}
+
+// Validate checks that the overlay has either text or an image.
+func (o Overlay) Validate() error {
+	if (o.Text == "") == (o.Image == "") {
+		return errors.New("an overlay needs either text or an image")
+	}
+	if o.Scale < 0 {
+		return errors.New("the scale of an overlay can't be negative")
+	}
+	if !o.Start.IsZero() && !o.End.IsZero() && o.End.Before(o.Start) {
+		return errors.New("an overlay can't end before it starts")
+	}
+	return nil
+}
+
+// Layer is a transparent layer on top of a canvas, the first layer is drawn
+// first.
+type Layer struct {
+	Name string `json:"name"`
+	// Opacity is multiplied with the alpha of the pixels of the layer, it is
+	// opaque when missing
+	Opacity *uint8 `json:"opacity"`
+	Hidden  bool   `json:"hidden"`
+}
+
+type Canvas struct {
+	Width      int        `json:"width"`
+	Height     int        `json:"height"`
+	Background Background `json:"background"`
+	Decay      Decay      `json:"decay"`
+	// Deep canvases keep 16 bits per channel for smoother blending
+	Deep bool `json:"deep"`
+	// Palette are the only colors, as rrggbb, that can be drawn on the
+	// canvas. Without a palette every color can be used.
+	Palette []string `json:"palette"`
+	// Overlays are shown over the canvas in the streams
+	Overlays []Overlay `json:"overlays"`
+	Layers   []Layer   `json:"layers"`
+	// WriteLayer is the name of the layer clients write to, without it they
+	// write to the canvas itself
+	WriteLayer string `json:"write_layer"`
+}
+
+type Canvases struct {
+	Main Canvas `json:"main"`
+	Icon Canvas `json:"icon"`
+}
+
+type Stream struct {
+	JpegQuality int      `json:"jpeg_quality"`
+	JpegTimer   Duration `json:"jpeg_timer"`
+	// JpegPing is how often a frame is sent when nothing changes
+	JpegPing    Duration `json:"jpeg_ping"`
+	IconQuality int      `json:"icon_quality"`
+	IconTimer   Duration `json:"icon_timer"`
+	StatsTimer  Duration `json:"stats_timer"`
+}
+
+// Limits are the limits for clients, 0 means unlimited. The amount of clients
+// is only limited for pixelflut connections.
+type Limits struct {
+	MaxClients      int `json:"max_clients"`
+	MaxClientsPerIP int `json:"max_clients_per_ip"`
+	// PixelRate is the amount of pixels a client can set every second
+	PixelRate int `json:"pixel_rate"`
+	// Timeout disconnects clients that didn't send anything for this long
+	Timeout Duration `json:"timeout"`
+}
+
+// Persistence saves the canvasses in Dir every Interval and when the server
+// stops, they are loaded again on startup. It is disabled without a Dir.
+type Persistence struct {
+	Dir      string   `json:"dir"`
+	Interval Duration `json:"interval"`
+}
+
+// Files are the paths of the other files the server reads.
+type Files struct {
+	PixelflutACL string `json:"pixelflut_acl"`
+	WebACL       string `json:"web_acl"`
+	Protected    string `json:"protected"`
+	Teams        string `json:"teams"`
+}
+
+type Config struct {
+	Listeners   Listeners   `json:"listeners"`
+	Canvases    Canvases    `json:"canvases"`
+	Stream      Stream      `json:"stream"`
+	Limits      Limits      `json:"limits"`
+	Persistence Persistence `json:"persistence"`
+	Files       Files       `json:"files"`
+	AdminToken  string      `json:"admin_token"`
+}
+
+var defaultDecay = Decay{Interval: Duration(time.Minute), Step: 8, Age: Duration(time.Hour)}
+
+// Default returns the configuration that is used for everything that isn't
+// in the config file or given as a flag.
+func Default() Config {
+	return Config{
+		Listeners: Listeners{
+			Pixelflut:         ":7791",
+			PixelflutExternal: "55282",
+			Web:               ":7792",
+		},
+		Canvases: Canvases{
+			Main: Canvas{Width: 800, Height: 600, Background: Background{Mode: "random", Color: "000000", Color2: "ffffff", Size: 16}, Decay: defaultDecay},
+			Icon: Canvas{Width: 32, Height: 32, Background: Background{Mode: "random", Color: "000000", Color2: "ffffff", Size: 4}, Decay: defaultDecay},
+		},
+		Stream: Stream{
+			JpegQuality: 75,
+			JpegTimer:   Duration(25 * time.Millisecond),
+			JpegPing:    Duration(25 * time.Second),
+			IconQuality: 90,
+			IconTimer:   Duration(25 * time.Millisecond),
+			StatsTimer:  Duration(200 * time.Millisecond),
+		},
+		Persistence: Persistence{
+			Interval: Duration(time.Minute),
+		},
+	}
+}
+
+// Load reads the config file at path on top of the defaults.
+func Load(path string) (Config, error) {
+	config := Default()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+func (c Canvas) validate(name string) error {
+	if c.Width < 1 || c.Width > 0xffff || c.Height < 1 || c.Height > 0xffff {
+		return fmt.Errorf("canvases.%s: the size should be between 1 and 65535, not %dx%d", name, c.Width, c.Height)
+	}
+	switch c.Background.Mode {
+	case "solid", "random", "gradient":
+	case "checkerboard":
+		if c.Background.Size < 1 {
+			return fmt.Errorf("canvases.%s.background.size should be positive", name)
+		}
+	case "image":
+		if c.Background.Image == "" {
+			return fmt.Errorf("canvases.%s.background.image can't be empty", name)
+		}
+	default:
+		return fmt.Errorf("canvases.%s.background.mode %q is unknown", name, c.Background.Mode)
+	}
+	switch c.Decay.Mode {
+	case "", "wipe":
+	case "fade":
+		if c.Decay.Step < 1 || c.Decay.Step > 255 {
+			return fmt.Errorf("canvases.%s.decay.step should be between 1 and 255", name)
+		}
+	case "stale":
+		if c.Decay.Age <= 0 {
+			return fmt.Errorf("canvases.%s.decay.age should be positive", name)
+		}
+	default:
+		return fmt.Errorf("canvases.%s.decay.mode %q is unknown", name, c.Decay.Mode)
+	}
+	if len(c.Palette) > 256 {
+		return fmt.Errorf("canvases.%s.palette can't have more than 256 colors", name)
+	}
+	if c.Decay.Mode != "" && c.Decay.Interval <= 0 {
+		return fmt.Errorf("canvases.%s.decay.interval should be positive", name)
+	}
+	for i, overlay := range c.Overlays {
+		if err := overlay.Validate(); err != nil {
+			return fmt.Errorf("canvases.%s.overlays[%d]: %w", name, i, err)
+		}
+	}
+	found := c.WriteLayer == ""
+	for _, layer := range c.Layers {
+		found = found || layer.Name == c.WriteLayer
+	}
+	if !found {
+		return fmt.Errorf("canvases.%s.write_layer %q is not one of its layers", name, c.WriteLayer)
+	}
+	return nil
+}
+
+// Validate checks that the configuration makes sense, it returns all
+// problems at once.
+func (c Config) Validate() error {
+	errs := []error{}
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Listeners.Pixelflut != "", "listeners.pixelflut can't be empty")
+	check(c.Listeners.Web != "", "listeners.web can't be empty")
+	errs = append(errs, c.Canvases.Main.validate("main"), c.Canvases.Icon.validate("icon"))
+	check(c.Stream.JpegQuality >= 1 && c.Stream.JpegQuality <= 100, "stream.jpeg_quality should be between 1 and 100")
+	check(c.Stream.IconQuality >= 1 && c.Stream.IconQuality <= 100, "stream.icon_quality should be between 1 and 100")
+	check(c.Stream.JpegTimer > 0, "stream.jpeg_timer should be positive")
+	check(c.Stream.JpegPing > 0, "stream.jpeg_ping should be positive")
+	check(c.Stream.IconTimer > 0, "stream.icon_timer should be positive")
+	check(c.Stream.StatsTimer > 0, "stream.stats_timer should be positive")
+	check(c.Limits.MaxClients >= 0, "limits.max_clients can't be negative")
+	check(c.Limits.MaxClientsPerIP >= 0, "limits.max_clients_per_ip can't be negative")
+	check(c.Limits.PixelRate >= 0, "limits.pixel_rate can't be negative")
+	check(c.Limits.Timeout >= 0, "limits.timeout can't be negative")
+	check(c.Persistence.Dir == "" || c.Persistence.Interval > 0, "persistence.interval should be positive")
+	return errors.Join(errs...)
+}
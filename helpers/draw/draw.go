@@ -0,0 +1,145 @@
+/*
+Package draw rasterizes shapes onto the canvasses, for everything that draws
+on them that isn't pixelflut.
+*/
+package draw
+
+import (
+	"errors"
+	"image"
+	"math"
+
+	"github.com/itepastra/flutties/types"
+)
+
+// Canvas is what a pen draws on, like a grid.
+type Canvas interface {
+	Bounds() image.Rectangle
+	Get(x uint16, y uint16) (uint32, error)
+	Set(xy uint32, c uint32, client *types.Client) error
+}
+
+// Pen writes the pixels of shapes to a canvas for a client. Pixels in
+// protected regions or zones of other teams are skipped, any other error
+// stops the shape and is kept in Err. Pixels is the amount of pixels that
+// were written.
+type Pen struct {
+	canvas Canvas
+	client *types.Client
+	color  func(x int, y int) uint32
+	Pixels int
+	Err    error
+}
+
+// NewPen returns a pen that draws color, which is blended with the blend
+// mode of client.
+func NewPen(canvas Canvas, color uint32, client *types.Client) *Pen {
+	return &Pen{canvas: canvas, client: client, color: func(int, int) uint32 { return color }}
+}
+
+// NewEraser returns a pen that draws background with alpha.
+func NewEraser(canvas Canvas, background Canvas, alpha byte, client *types.Client) *Pen {
+	color := func(x int, y int) uint32 {
+		c, _ := background.Get(uint16(x), uint16(y))
+		return c&0xffffff | uint32(alpha)<<24
+	}
+	return &Pen{canvas: canvas, client: client, color: color}
+}
+
+// Plot writes the pixel at x, y. It returns false when the rest of the shape
+// can't be written either.
+func (p *Pen) Plot(x int, y int) bool {
+	if p.Err != nil {
+		return false
+	}
+	if x < 0 || y < 0 || x > 0xffff || y > 0xffff {
+		p.Err = types.ErrOutOfBounds
+		return false
+	}
+	err := p.canvas.Set(uint32(y)<<16|uint32(x), p.color(x, y), p.client)
+	switch {
+	case err == nil:
+		p.Pixels++
+	case errors.Is(err, types.ErrProtected), errors.Is(err, types.ErrZone):
+	default:
+		p.Err = err
+	}
+	return p.Err == nil
+}
+
+// Pixel draws the single pixel at x, y, unlike the shapes it fails when the
+// pixel is outside of the canvas.
+func (p *Pen) Pixel(x int, y int) error {
+	p.Plot(x, y)
+	return p.Err
+}
+
+// Line draws every pixel closer than size to the line from x0, y0 to x1, y1,
+// so a line of a single point is a circle.
+func (p *Pen) Line(x0 int, y0 int, x1 int, y1 int, size int) error {
+	dx, dy := float64(x1-x0), float64(y1-y0)
+	length := dx*dx + dy*dy
+	area := image.Rect(x0, y0, x1, y1).Inset(-size).Intersect(p.canvas.Bounds())
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			t := 0.0
+			if length > 0 {
+				t = math.Max(0, math.Min(1, (float64(x-x0)*dx+float64(y-y0)*dy)/length))
+			}
+			ex, ey := float64(x-x0)-t*dx, float64(y-y0)-t*dy
+			if ex*ex+ey*ey < float64(size)*float64(size) && !p.Plot(x, y) {
+				return p.Err
+			}
+		}
+	}
+	return p.Err
+}
+
+// Circle draws every pixel closer than radius to x, y.
+func (p *Pen) Circle(x int, y int, radius int) error {
+	return p.Line(x, y, x, y, radius)
+}
+
+// Rect draws the filled rectangle with corners x0, y0 and x1, y1.
+func (p *Pen) Rect(x0 int, y0 int, x1 int, y1 int) error {
+	area := image.Rect(x0, y0, x1, y1)
+	area.Max = area.Max.Add(image.Pt(1, 1))
+	area = area.Intersect(p.canvas.Bounds())
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			if !p.Plot(x, y) {
+				return p.Err
+			}
+		}
+	}
+	return p.Err
+}
+
+// Fill flood fills the area around x, y that has the same color as x, y.
+func (p *Pen) Fill(x int, y int) error {
+	sizeX, sizeY := p.canvas.Bounds().Dx(), p.canvas.Bounds().Dy()
+	if x < 0 || y < 0 || x >= sizeX || y >= sizeY {
+		return types.ErrOutOfBounds
+	}
+	target, _ := p.canvas.Get(uint16(x), uint16(y))
+	seen := make([]bool, sizeX*sizeY)
+	seen[y*sizeX+x] = true
+	queue := []image.Point{{x, y}}
+	for len(queue) > 0 {
+		point := queue[0]
+		queue = queue[1:]
+		if !p.Plot(point.X, point.Y) {
+			return p.Err
+		}
+		for _, n := range []image.Point{point.Add(image.Pt(-1, 0)), point.Add(image.Pt(1, 0)), point.Add(image.Pt(0, -1)), point.Add(image.Pt(0, 1))} {
+			if n.X < 0 || n.Y < 0 || n.X >= sizeX || n.Y >= sizeY || seen[n.Y*sizeX+n.X] {
+				continue
+			}
+			seen[n.Y*sizeX+n.X] = true
+			if c, _ := p.canvas.Get(uint16(n.X), uint16(n.Y)); c == target {
+				queue = append(queue, n)
+			}
+		}
+	}
+	return p.Err
+}
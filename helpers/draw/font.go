@@ -0,0 +1,83 @@
+package draw
+
+import (
+	_ "embed"
+	"strconv"
+	"strings"
+)
+
+// The font has a glyph for every printable ascii character, the last row is
+// for the descenders of letters like g and y. Glyphs are a pixel apart.
+const (
+	GLYPH_WIDTH  = 5
+	GLYPH_HEIGHT = 8
+	GLYPH_SPACE  = 1
+)
+
+// glyph is a character of the font, every row has a bit for every pixel
+// with the leftmost pixel in the highest bit.
+type glyph [GLYPH_HEIGHT]uint8
+
+// fontData has a block for every glyph, the character as a hex number and
+// then a line per row, with a # for every pixel that is set.
+//
+//go:embed font.txt
+var fontData string
+
+var font = parseFont(fontData)
+
+func parseFont(data string) map[rune]glyph {
+	glyphs := map[rune]glyph{}
+	for _, block := range strings.Split(strings.TrimSpace(data), "\n\n") {
+		lines := strings.Split(block, "\n")
+		hex, _, _ := strings.Cut(lines[0], " ")
+		char, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(lines) != GLYPH_HEIGHT+1 {
+			panic("invalid glyph in font: " + lines[0])
+		}
+		var g glyph
+		for y, row := range lines[1:] {
+			for x := 0; x < GLYPH_WIDTH && x < len(row); x++ {
+				if row[x] == '#' {
+					g[y] |= 1 << (GLYPH_WIDTH - 1 - x)
+				}
+			}
+		}
+		glyphs[rune(char)] = g
+	}
+	return glyphs
+}
+
+// Text draws text with its top left corner at x, y and every pixel of the
+// font as a square of scale pixels, lines are separated by newlines.
+// Characters the font doesn't have are drawn as a question mark. With a
+// background pen the space around the characters is drawn with it.
+func (p *Pen) Text(x int, y int, text string, scale int, background *Pen) error {
+	scale = max(scale, 1)
+	for i, line := range strings.Split(text, "\n") {
+		top := y + i*(GLYPH_HEIGHT+GLYPH_SPACE)*scale
+		for j, char := range []rune(line) {
+			left := x + j*(GLYPH_WIDTH+GLYPH_SPACE)*scale
+			g, ok := font[char]
+			if !ok {
+				g = font['?']
+			}
+			for row := 0; row < GLYPH_HEIGHT+GLYPH_SPACE; row++ {
+				for column := 0; column < GLYPH_WIDTH+GLYPH_SPACE; column++ {
+					pen := background
+					if row < GLYPH_HEIGHT && column < GLYPH_WIDTH && g[row]>>(GLYPH_WIDTH-1-column)&1 == 1 {
+						pen = p
+					}
+					if pen == nil {
+						continue
+					}
+					px, py := left+column*scale, top+row*scale
+					if err := pen.Rect(px, py, px+scale-1, py+scale-1); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
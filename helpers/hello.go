@@ -0,0 +1,151 @@
+package helpers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/itepastra/flutties/types"
+)
+
+// PROTOCOL_VERSION is the newest version of the protocol the server speaks.
+const PROTOCOL_VERSION = 1
+
+// The features a client can ask for in the handshake.
+const (
+	FEATURE_SUBSCRIBE uint32 = 1 << iota
+	FEATURE_OFFSET
+	FEATURE_COMPRESSION
+)
+
+// SUPPORTED_FEATURES are the features the server agrees to.
+const SUPPORTED_FEATURES = FEATURE_SUBSCRIBE | FEATURE_OFFSET | FEATURE_COMPRESSION
+
+var (
+	// featureNames are the names of the features in the text protocol, in
+	// the order of their bits.
+	featureNames     = []string{"subscribe", "offset", "compression"}
+	ErrNotNegotiated = errors.New("the feature was not negotiated with HELLO")
+	// compressionMethods are the methods COMPRESS can switch to, in the order
+	// of their number in the binary protocol.
+	compressionMethods = []string{"flate", "zlib"}
+)
+
+// negotiate agrees on a version and features with the client, unknown or
+// unsupported features are left out.
+func negotiate(client *types.Client, version uint32, wanted uint32) (uint32, uint32) {
+	version = min(version, PROTOCOL_VERSION)
+	features := wanted & SUPPORTED_FEATURES
+	client.Negotiate(version, features)
+	return version, features
+}
+
+// helloCmd handles HELLO <version> <feature>..., it replies with the version
+// and features the server agreed to.
+func helloCmd(rest []byte, client *types.Client, writer io.Writer) error {
+	fields := bytes.Fields(rest)
+	if len(fields) == 0 {
+		return errors.New("HELLO needs a version")
+	}
+	version, err := strconv.ParseUint(string(fields[0]), 10, 8)
+	if err != nil {
+		return err
+	}
+	var wanted uint32
+	for _, name := range fields[1:] {
+		if i := slices.Index(featureNames, string(name)); i >= 0 {
+			wanted |= 1 << i
+		}
+	}
+	agreed, features := negotiate(client, uint32(version), wanted)
+	reply := fmt.Sprintf("HELLO %d", agreed)
+	for i, name := range featureNames {
+		if features&(1<<i) != 0 {
+			reply += " " + name
+		}
+	}
+	_, err = writer.Write([]byte(reply + "\n"))
+	return err
+}
+
+// HelloBin is the handshake of the binary protocol, the client sends its
+// version and the features it wants as a bitmask. The reply is itself and
+// the version and features the server agreed to.
+func HelloBin(writer io.Writer, cmd []byte, client *types.Client) (int, []byte, error) {
+	if cmdLen(cmd, 3) {
+		return 0, nil, nil
+	}
+	version, features := negotiate(client, uint32(cmd[1]), uint32(cmd[2]))
+	_, err := writer.Write([]byte{cmd[0], byte(version), byte(features)})
+	return 3, cmd[:3], err
+}
+
+// offsetCmd handles OFFSET <x> <y>, which makes every following coordinate of
+// the client relative to (x, y).
+func offsetCmd(rest []byte, client *types.Client) error {
+	if !client.Has(FEATURE_OFFSET) {
+		return ErrNotNegotiated
+	}
+	fields := bytes.Fields(rest)
+	if len(fields) != 2 {
+		return errors.New("OFFSET needs x and y")
+	}
+	x, err := strconv.ParseUint(string(fields[0]), 10, 16)
+	if err != nil {
+		return err
+	}
+	y, err := strconv.ParseUint(string(fields[1]), 10, 16)
+	if err != nil {
+		return err
+	}
+	client.SetOffset(uint16(x), uint16(y))
+	return nil
+}
+
+// OffsetBin is OFFSET in the binary protocol, with x and y as little endian
+// uint16 like the coordinates of the other commands.
+func OffsetBin(cmd []byte, client *types.Client) (int, []byte, error) {
+	if cmdLen(cmd, 5) {
+		return 0, nil, nil
+	}
+	if !client.Has(FEATURE_OFFSET) {
+		return 5, cmd[:5], ErrNotNegotiated
+	}
+	client.SetOffset(uint16(cmd[2])<<8|uint16(cmd[1]), uint16(cmd[4])<<8|uint16(cmd[3]))
+	return 5, cmd[:5], nil
+}
+
+// compressCmd handles COMPRESS <method>, everything the client sends after
+// it is compressed with method.
+func compressCmd(rest []byte, client *types.Client, writer io.Writer) error {
+	if !client.Has(FEATURE_COMPRESSION) {
+		return ErrNotNegotiated
+	}
+	method := string(bytes.TrimSpace(rest))
+	if !slices.Contains(compressionMethods, method) {
+		return fmt.Errorf("unknown compression method %q", method)
+	}
+	client.Compress(method)
+	_, err := writer.Write([]byte("COMPRESS " + method + "\n"))
+	return err
+}
+
+// CompressBin is COMPRESS in the binary protocol, the method is 0 for flate
+// and 1 for zlib. The reply is itself and the method.
+func CompressBin(writer io.Writer, cmd []byte, client *types.Client) (int, []byte, error) {
+	if cmdLen(cmd, 2) {
+		return 0, nil, nil
+	}
+	if !client.Has(FEATURE_COMPRESSION) {
+		return 2, cmd[:2], ErrNotNegotiated
+	}
+	if int(cmd[1]) >= len(compressionMethods) {
+		return 2, cmd[:2], errors.New("unknown compression method")
+	}
+	client.Compress(compressionMethods[cmd[1]])
+	_, err := writer.Write(cmd[:2])
+	return 2, cmd[:2], err
+}
@@ -0,0 +1,88 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/itepastra/flutties/types"
+)
+
+// The color modes of a canvas in the reply to INFO.
+const (
+	COLOR_MODE_RGB     byte = 0
+	COLOR_MODE_DEEP         = 1
+	COLOR_MODE_PALETTE      = 2
+)
+
+// The flags of a canvas in the reply to INFO.
+const (
+	FLAG_READ_ONLY byte = 1 << iota
+	FLAG_PALETTE
+	FLAG_DEEP
+)
+
+var canvasNames = [types.GRID_AMOUNT]string{"main", "icon"}
+
+func colorMode(grid *types.Grid) byte {
+	if len(grid.Palette.Colors()) > 0 {
+		return COLOR_MODE_PALETTE
+	}
+	if grid.Deep() {
+		return COLOR_MODE_DEEP
+	}
+	return COLOR_MODE_RGB
+}
+
+func flags(grid *types.Grid) (flags byte) {
+	if grid.Frozen() {
+		flags |= FLAG_READ_ONLY
+	}
+	if len(grid.Palette.Colors()) > 0 {
+		flags |= FLAG_PALETTE
+	}
+	if grid.Deep() {
+		flags |= FLAG_DEEP
+	}
+	return
+}
+
+// InfoBin describes every canvas. The reply is itself and the amount of
+// canvases, followed for every canvas by its id, width and height as big
+// endian uint16, color mode, flags, the amount of palette colors as a big
+// endian uint16 and the palette colors as r, g, b.
+func InfoBin(writer io.Writer, cmd []byte, grids [types.GRID_AMOUNT]*types.Grid) (int, []byte, error) {
+	reply := []byte{cmd[0], types.GRID_AMOUNT}
+	for _, grid := range grids {
+		sizeX, sizeY := grid.Size()
+		colors := grid.Palette.Colors()
+		reply = append(reply, grid.Index)
+		reply = binary.BigEndian.AppendUint16(reply, uint16(sizeX))
+		reply = binary.BigEndian.AppendUint16(reply, uint16(sizeY))
+		reply = append(reply, colorMode(grid), flags(grid))
+		reply = binary.BigEndian.AppendUint16(reply, uint16(len(colors)))
+		for _, c := range colors {
+			r, g, b, _ := types.Channels(c)
+			reply = append(reply, r, g, b)
+		}
+	}
+	_, err := writer.Write(reply)
+	return 1, cmd[:1], err
+}
+
+// infoCmd is the reply to INFO in the text protocol, it has the same
+// information as InfoBin as key=value pairs. The first line has the amount
+// of canvases, the palettes are listed by PALETTE.
+func infoCmd(grids [types.GRID_AMOUNT]*types.Grid) []byte {
+	var info bytes.Buffer
+	fmt.Fprintf(&info, "INFO canvases=%d\n", types.GRID_AMOUNT)
+	modes := map[byte]string{COLOR_MODE_RGB: "rgb", COLOR_MODE_DEEP: "deep", COLOR_MODE_PALETTE: "palette"}
+	for _, grid := range grids {
+		sizeX, sizeY := grid.Size()
+		fmt.Fprintf(&info, "INFO id=%d name=%s width=%d height=%d mode=%s readonly=%t palette=%d deep=%t\n",
+			grid.Index, canvasNames[grid.Index], sizeX, sizeY, modes[colorMode(grid)],
+			grid.Frozen(), len(grid.Palette.Colors()), grid.Deep())
+	}
+	return info.Bytes()
+}
@@ -0,0 +1,79 @@
+package multi
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var ErrGroupFull = errors.New("there are too many keys")
+
+// StartFunc starts writing to w for key. joined gets a value whenever a
+// Writer is added to w, and done is closed when the last one is removed.
+type StartFunc[K comparable] func(key K, w MapWriter, joined <-chan struct{}, done <-chan struct{})
+
+type member struct {
+	MapWriter
+	joined chan struct{}
+	done   chan struct{}
+}
+
+// Group keeps a MapWriter for every key that has Writers. The MapWriter of a
+// key is started in its own goroutine when its first Writer is added and
+// stopped when its last Writer is removed, so keys nobody listens to cost
+// nothing.
+type Group[K comparable] struct {
+	start   StartFunc[K]
+	limit   int
+	members map[K]*member
+	lock    *sync.Mutex
+}
+
+// NewGroup creates a Group with at most limit keys at once, 0 is unlimited.
+func NewGroup[K comparable](limit int, start StartFunc[K]) *Group[K] {
+	return &Group[K]{start, limit, make(map[K]*member), &sync.Mutex{}}
+}
+
+// Add puts w into the MapWriter of key, starting it when it is new.
+func (g *Group[K]) Add(key K, w io.Writer) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	m, found := g.members[key]
+	if !found {
+		if g.limit > 0 && len(g.members) >= g.limit {
+			return ErrGroupFull
+		}
+		m = &member{NewMapWriter(), make(chan struct{}, 1), make(chan struct{})}
+		g.members[key] = m
+		go g.start(key, m.MapWriter, m.joined, m.done)
+	}
+	m.Add(w)
+	select {
+	case m.joined <- struct{}{}:
+	default:
+		// the last join isn't handled yet, it covers this one too
+	}
+	return nil
+}
+
+// Remove deletes w from the MapWriter of key, and stops it when w was the
+// last Writer.
+func (g *Group[K]) Remove(key K, w io.Writer) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	m, found := g.members[key]
+	if !found {
+		return
+	}
+	if m.Remove(w) == 0 {
+		close(m.done)
+		delete(g.members, key)
+	}
+}
+
+// Size returns the amount of keys that have Writers.
+func (g *Group[K]) Size() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return len(g.members)
+}
@@ -1,6 +1,6 @@
 /*
 Package multi implements a threadsafe writer interface that multiplies its input
-to a map of writers.
+to a map of writers, and groups of those that only run while they have writers.
 */
 package multi
 
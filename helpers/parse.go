@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strconv"
 	"unsafe"
 
@@ -19,14 +20,29 @@ It supports the pixelflut protocol,
 and also the binary protocol from the README
 `)
 
+var paletteHelp = []byte(`Canvases with a palette only have the colors below,
+set them with PX <x> <y> #<index>
+`)
+
 const (
 	INFO            byte = 0x10
 	SIZE                 = 0x20
+	CONTROL              = 0x30
+	TOKEN                = 0x30
+	SUBSCRIBE            = 0x31
+	MODE                 = 0x32
+	HELLO                = 0x33
+	BATCH                = 0x34
+	OFFSET               = 0x35
+	COMPRESS             = 0x36
+	SET_RGB565           = 0x60
+	SET_INDEX            = 0x70
 	GET_PIXEL_VALUE      = 0x80
 	SET_GRAYSCALE        = 0x90
 	SET_HALF_RGBA        = 0xA0
 	SET_RGB              = 0xB0
 	SET_RGBA             = 0xC0
+	SET_RGBA16           = 0xD0
 	SOUND_LOOP           = 0xE0
 	SOUND_ONCE           = 0xF0
 	H                    = byte('H')
@@ -36,40 +52,45 @@ const (
 )
 
 var (
-	HELP_COMMAND          = []byte("HELP")
-	SIZE_COMMAND          = []byte("SIZE")
-	SIZE_ICON_COMMAND     = []byte("ISIZE")
-	PX_COMMAND_START      = []byte("PX ")
-	PX_ICON_COMMAND_START = []byte("IPX ")
-	MAIN_GRID_INDEX       = 0
-	ICON_GRID_INDEX       = 1
+	HELP_COMMAND            = []byte("HELP")
+	SIZE_COMMAND            = []byte("SIZE")
+	SIZE_ICON_COMMAND       = []byte("ISIZE")
+	PX_COMMAND_START        = []byte("PX ")
+	PX_ICON_COMMAND_START   = []byte("IPX ")
+	TOKEN_COMMAND_START     = []byte("TOKEN ")
+	SUBSCRIBE_COMMAND       = []byte("SUBSCRIBE")
+	MODE_COMMAND            = []byte("MODE")
+	PALETTE_COMMAND         = []byte("PALETTE")
+	INFO_COMMAND            = []byte("INFO")
+	HELLO_COMMAND_START     = []byte("HELLO ")
+	OFFSET_COMMAND_START    = []byte("OFFSET ")
+	COMPRESS_COMMAND_START  = []byte("COMPRESS ")
+	TEXT_COMMAND_START      = []byte("TEXT ")
+	TEXT_ICON_COMMAND_START = []byte("ITEXT ")
+	MAIN_GRID_INDEX         = 0
+	ICON_GRID_INDEX         = 1
 )
 
+// parseHex parses a color as ww, rrggbb or rrggbbaa.
 func parseHex(part string) (uint32, error) {
 	data, err := hex.DecodeString(part)
 	if err != nil {
 		return 0, err
 	}
-	if len(data) == 1 {
-		return uint32(data[0])<<24 |
-			uint32(data[0])<<16 |
-			uint32(data[0])<<8 | 0xff, nil
-	}
-	if len(data) == 3 {
-		return uint32(data[0])<<24 |
-			uint32(data[1])<<16 |
-			uint32(data[2])<<8 | 0xff, nil
-	}
-	if len(data) == 4 {
-		return uint32(data[0])<<24 |
-			uint32(data[1])<<16 |
-			uint32(data[2])<<8 |
-			uint32(data[3]), nil
+	switch len(data) {
+	case 1:
+		return types.RGBA(data[0], data[0], data[0], 0xff), nil
+	case 3:
+		return types.RGBA(data[0], data[1], data[2], 0xff), nil
+	case 4:
+		return types.RGBA(data[0], data[1], data[2], data[3]), nil
 	}
 	return 0, errors.New("incorrect number of bytes")
 }
 
-func parsePx(command []byte) (x uint16, y uint16, found bool, color uint32, err error) {
+// parsePx parses the arguments of PX, when the color is given as #idx indexed
+// is set and color is the index in the palette.
+func parsePx(command []byte) (x uint16, y uint16, found bool, indexed bool, color uint32, err error) {
 	str := command
 	trimmed := bytes.Trim(str, "\n \x00")
 	parts := bytes.Split(trimmed, []byte{' '})
@@ -91,8 +112,14 @@ func parsePx(command []byte) (x uint16, y uint16, found bool, color uint32, err
 				return
 			}
 		case 2:
-			color, err = parseHex(string(p))
 			found = true
+			if index, isIndex := bytes.CutPrefix(p, []byte{'#'}); isIndex {
+				var i uint64
+				i, err = strconv.ParseUint(string(index), 10, 8)
+				indexed, color = true, uint32(i)
+			} else {
+				color, err = parseHex(string(p))
+			}
 			if err != nil {
 				return
 			}
@@ -101,10 +128,6 @@ func parsePx(command []byte) (x uint16, y uint16, found bool, color uint32, err
 	return
 }
 
-func pack(a, b, c, d byte) uint32 {
-	return uint32(d)<<24 | uint32(c)<<16 | uint32(b)<<8 | uint32(a)
-}
-
 func cmdLen(cmd []byte, desired int) bool {
 	return len(cmd) < desired
 }
@@ -113,41 +136,102 @@ func getCanvasId(cmd byte) byte {
 	return cmd & 0x0f
 }
 
-func getxy(cmd []byte) uint32 {
-	return *(*uint32)(unsafe.Pointer(&cmd[1]))
+var ErrUnknownCanvas = errors.New("unknown canvas")
+
+// canvasCommandLengths are the lengths of the binary commands that have the
+// id of a canvas in the lower 4 bits of their first byte.
+var canvasCommandLengths = map[byte]int{
+	SIZE:            1,
+	SET_RGB565:      7,
+	SET_INDEX:       6,
+	GET_PIXEL_VALUE: 5,
+	SET_GRAYSCALE:   6,
+	SET_HALF_RGBA:   7,
+	SET_RGB:         8,
+	SET_RGBA:        9,
+	SET_RGBA16:      13,
 }
 
-func getrgb(cmd []byte) uint32 {
-	return *(*uint32)(unsafe.Pointer(&cmd[5]))
+// UnknownCanvas reports whether the binary command starting with op is for a
+// canvas that doesn't exist.
+func UnknownCanvas(op byte) bool {
+	_, found := canvasCommandLengths[op&0xf0]
+	return found && getCanvasId(op) >= types.GRID_AMOUNT
 }
 
-func HelpBin(writer io.Writer, cmd []byte) (int, []byte, error) {
-	_, err := writer.Write([]byte(fmt.Sprintf("There are %d grids", types.GRID_AMOUNT)))
-	return 1, cmd[:1], err
+// UnknownCanvasBin skips a binary command for a canvas that doesn't exist.
+// SIZE is answered with a size of 0 by 0, so clients can probe which
+// canvasses exist.
+func UnknownCanvasBin(writer io.Writer, cmd []byte) (int, []byte, error) {
+	if cmd[0]&0xf0 == SIZE {
+		_, err := writer.Write([]byte{cmd[0], 0, 0, 0, 0})
+		return 1, cmd[:1], err
+	}
+	length := canvasCommandLengths[cmd[0]&0xf0]
+	if cmdLen(cmd, length) {
+		return 0, nil, nil
+	}
+	return length, cmd[:length], ErrUnknownCanvas
 }
 
-func InfoBin(writer io.Writer, cmd []byte, grids [types.GRID_AMOUNT]*types.Grid) (int, []byte, error) {
-	canvasId := getCanvasId(cmd[0])
-	_, err := writer.Write([]byte{
-		cmd[0],
-		byte(grids[canvasId].SizeX >> 8),
-		byte(grids[canvasId].SizeX),
-		byte(grids[canvasId].SizeY >> 8),
-		byte(grids[canvasId].SizeY),
-	})
+// getxy reads the coordinates of a binary command, relative to the offset of
+// client.
+func getxy(cmd []byte, client *types.Client) uint32 {
+	return client.Translate(*(*uint32)(unsafe.Pointer(&cmd[1])))
+}
+
+func SizeBin(writer io.Writer, cmd []byte, grids [types.GRID_AMOUNT]*types.Grid) (int, []byte, error) {
+	_, err := writer.Write(sizeReply(grids[getCanvasId(cmd[0])]))
 	return 1, cmd[:1], err
 }
 
-func GetPixelBin(writer io.Writer, cmd []byte, grids [types.GRID_AMOUNT]*types.Grid) (int, []byte, error) {
+// sizeReply is the reply to SIZE for grid in the binary protocol.
+func sizeReply(grid *types.Grid) []byte {
+	sizeX, sizeY := grid.Size()
+	return []byte{
+		SIZE | grid.Index,
+		byte(sizeX >> 8),
+		byte(sizeX),
+		byte(sizeY >> 8),
+		byte(sizeY),
+	}
+}
+
+// sizeCmd is the reply to SIZE for grid in the text protocol, pushed
+// messages about the icon start with ISIZE so they can be told apart.
+func sizeCmd(grid *types.Grid, push bool) []byte {
+	prefix := SIZE_COMMAND
+	if push && grid.Index == byte(ICON_GRID_INDEX) {
+		prefix = SIZE_ICON_COMMAND
+	}
+	sizeX, sizeY := grid.Size()
+	return []byte(fmt.Sprintf("%s %d %d\n", prefix, sizeX, sizeY))
+}
+
+// NotifyResize tells every subscribed client the new size of grid.
+func NotifyResize(registry *types.Registry, grid *types.Grid) {
+	for _, client := range registry.Clients() {
+		writer, binary, ok := client.Subscription()
+		if !ok {
+			continue
+		}
+		message := sizeCmd(grid, true)
+		if binary {
+			message = sizeReply(grid)
+		}
+		// a slow client shouldn't hold up the others
+		go writer.Write(message)
+	}
+}
+
+func GetPixelBin(writer io.Writer, cmd []byte, grids [types.GRID_AMOUNT]*types.Grid, client *types.Client) (int, []byte, error) {
 	canvasId := getCanvasId(cmd[0])
 	if cmdLen(cmd, 5) {
 		return 0, nil, nil
 	}
 
-	x := uint16(cmd[2])<<8 | uint16(cmd[1])
-	y := uint16(cmd[4])<<8 | uint16(cmd[3])
-
-	color, err := grids[canvasId].Get(x, y)
+	xy := getxy(cmd, client)
+	color, err := grids[canvasId].Get(uint16(xy), uint16(xy>>16))
 	if err != nil {
 		return 5, cmd[:5], err
 	}
@@ -157,27 +241,24 @@ func GetPixelBin(writer io.Writer, cmd []byte, grids [types.GRID_AMOUNT]*types.G
 		cmd[2],
 		cmd[3],
 		cmd[4],
-		byte(color >> 24),
-		byte(color >> 16),
+		byte(color),
 		byte(color >> 8),
+		byte(color >> 16),
 	})
 	return 5, cmd[:5], err
 }
 
-func SetGrayscaleBin(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid) (int, []byte, error) {
+func SetGrayscaleBin(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid, client *types.Client) (int, []byte, error) {
 	canvasId := getCanvasId(cmd[0])
 	if len(cmd) < 6 {
 		return 0, nil, nil
 	}
 
-	xy := *(*uint32)(unsafe.Pointer(&cmd[1]))
-	color := uint32(cmd[5])<<24 | uint32(cmd[5])<<16 | uint32(cmd[5])<<8 | 0xff
-
-	err := grids[canvasId].SetExact(xy, color)
+	err := grids[canvasId].Set(getxy(cmd, client), types.RGBA(cmd[5], cmd[5], cmd[5], 0xff), client)
 	return 6, cmd[:6], err
 }
 
-func SetHalfRGBABin(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid) (int, []byte, error) {
+func SetHalfRGBABin(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid, client *types.Client) (int, []byte, error) {
 	canvasId := getCanvasId(cmd[0])
 	if len(cmd) < 7 {
 		return 0, nil, nil
@@ -187,65 +268,255 @@ func SetHalfRGBABin(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid) (int, []by
 	g := (cmd[5]&0x0f)<<4 | (cmd[5] & 0x0f)
 	b := (cmd[6] & 0xf0) | (cmd[6]&0xf0)>>4
 	a := (cmd[6]&0x0f)<<4 | (cmd[6] & 0x0f)
-	err := grids[canvasId].Set(pack(cmd[1], cmd[2], cmd[3], cmd[4]), binary.BigEndian.Uint32([]byte{r, g, b, a}))
+	err := grids[canvasId].Set(getxy(cmd, client), types.RGBA(r, g, b, a), client)
 
 	return 7, cmd[:7], err
 }
 
-func SetRGBBin(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid) (int, []byte, error) {
+func SetRGBBin(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid, client *types.Client) (int, []byte, error) {
 	canvasId := getCanvasId(cmd[0])
 	if cmdLen(cmd, 8) {
 		return 0, nil, nil
 	}
-	err := grids[canvasId].SetExact(getxy(cmd), getrgb(cmd))
+	err := grids[canvasId].Set(getxy(cmd, client), types.RGBA(cmd[5], cmd[6], cmd[7], 0xff), client)
 	return 8, cmd[:8], err
 }
 
-func SetRGBABin(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid) (int, []byte, error) {
+func SetRGBABin(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid, client *types.Client) (int, []byte, error) {
 	canvasId := getCanvasId(cmd[0])
-	if cmdLen(cmd, 8) {
+	if cmdLen(cmd, 9) {
 		return 0, nil, nil
 	}
 
-	err := grids[canvasId].Set(pack(cmd[1], cmd[2], cmd[3], cmd[4]), uint32(cmd[5])<<24|uint32(cmd[6])<<16|uint32(cmd[7])<<8|uint32(cmd[8]))
+	err := grids[canvasId].Set(getxy(cmd, client), types.RGBA(cmd[5], cmd[6], cmd[7], cmd[8]), client)
 	return 9, cmd[:9], err
 }
 
-func TextCmd(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid, writer io.Writer) (err error) {
-	if bytes.Compare(cmd, HELP_COMMAND) == 0 {
-		_, err = writer.Write(helpMessage)
-	} else if bytes.Compare(cmd, SIZE_COMMAND) == 0 {
-		_, err = writer.Write([]byte(fmt.Sprintf("SIZE %d %d\n", grids[0].SizeX, grids[0].SizeY)))
-	} else if bytes.Compare(cmd, SIZE_ICON_COMMAND) == 0 {
-		_, err = writer.Write([]byte(fmt.Sprintf("SIZE %d %d\n", grids[1].SizeX, grids[1].SizeY)))
-	} else if rest, found := bytes.CutPrefix(cmd, PX_COMMAND_START); found {
-		x, y, found, color, err := parsePx(rest)
+// SetRGB565Bin sets a pixel to a color with 5 bits of red, 6 of green and 5
+// of blue in a little endian uint16.
+func SetRGB565Bin(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid, client *types.Client) (int, []byte, error) {
+	canvasId := getCanvasId(cmd[0])
+	if cmdLen(cmd, 7) {
+		return 0, nil, nil
+	}
+	color := types.RGB565(binary.LittleEndian.Uint16(cmd[5:]))
+	err := grids[canvasId].SetRGBA64(getxy(cmd, client), color, client)
+	return 7, cmd[:7], err
+}
+
+// SetRGBA16Bin sets a pixel to a color with 16 bits per channel, every
+// channel is a little endian uint16.
+func SetRGBA16Bin(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid, client *types.Client) (int, []byte, error) {
+	canvasId := getCanvasId(cmd[0])
+	if cmdLen(cmd, 13) {
+		return 0, nil, nil
+	}
+	err := grids[canvasId].SetRGBA64(getxy(cmd, client), binary.LittleEndian.Uint64(cmd[5:]), client)
+	return 13, cmd[:13], err
+}
+
+func pxCmd(rest []byte, grid *types.Grid, client *types.Client, writer io.Writer) error {
+	x, y, found, indexed, color, err := parsePx(rest)
+	if err != nil {
+		return err
+	}
+	xy := client.Translate(uint32(y)<<16 | uint32(x))
+	if !found { // a request for the current color
+		c, err := grid.Get(uint16(xy), uint16(xy>>16))
 		if err != nil {
 			return err
 		}
-		if !found { // a request for the current color
-			c, err := grids[MAIN_GRID_INDEX].Get(x, y)
-			if err != nil {
-				return err
-			}
-			_, err = writer.Write([]byte(fmt.Sprintf("PX %d %d %s\n", x, y, PxToHex(c))))
-		} else {
-			err = grids[MAIN_GRID_INDEX].Set(uint32(x)<<16|uint32(y), color)
+		_, err = writer.Write([]byte(fmt.Sprintf("PX %d %d %s\n", x, y, PxToHex(c))))
+		return err
+	}
+	if indexed {
+		return grid.SetIndex(xy, int(color), client)
+	}
+	return grid.Set(xy, color, client)
+}
+
+// helpCmd is the reply to HELP, the palettes are only explained when a
+// canvas has one.
+func helpCmd(grids [types.GRID_AMOUNT]*types.Grid) []byte {
+	help := slices.Clip(helpMessage)
+	if palettes := paletteCmd(grids); len(palettes) > 0 {
+		help = append(append(help, paletteHelp...), palettes...)
+	}
+	return help
+}
+
+// paletteCmd lists the palettes of the canvases that have one, as
+// PALETTE <canvas> rrggbb...
+func paletteCmd(grids [types.GRID_AMOUNT]*types.Grid) []byte {
+	var palettes bytes.Buffer
+	for _, grid := range grids {
+		colors := grid.Palette.Colors()
+		if len(colors) == 0 {
+			continue
 		}
-	} else if rest, found := bytes.CutPrefix(cmd, PX_ICON_COMMAND_START); found {
-		x, y, found, color, err := parsePx(rest)
+		fmt.Fprintf(&palettes, "PALETTE %d", grid.Index)
+		for _, c := range colors {
+			palettes.WriteString(" " + PxToHex(c))
+		}
+		palettes.WriteByte('\n')
+	}
+	return palettes.Bytes()
+}
+
+// SetIndexBin sets a pixel to a color of the palette of the canvas.
+func SetIndexBin(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid, client *types.Client) (int, []byte, error) {
+	canvasId := getCanvasId(cmd[0])
+	if cmdLen(cmd, 6) {
+		return 0, nil, nil
+	}
+	err := grids[canvasId].SetIndex(getxy(cmd, client), int(cmd[5]), client)
+	return 6, cmd[:6], err
+}
+
+// modeCmd sets the blend mode of the client when a mode is given, it replies
+// with the current mode.
+func modeCmd(rest []byte, client *types.Client, writer io.Writer) error {
+	var err error
+	if name := bytes.TrimSpace(rest); len(name) > 0 {
+		var mode types.BlendMode
+		if mode, err = types.ParseBlendMode(string(name)); err == nil {
+			client.SetBlendMode(mode)
+		}
+	}
+	if _, werr := writer.Write([]byte(fmt.Sprintf("MODE %s\n", client.BlendMode()))); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// ControlBin handles the commands that change the state of the connection
+// instead of a canvas, the lower nibble says which command it is.
+func ControlBin(writer io.Writer, cmd []byte, grids [types.GRID_AMOUNT]*types.Grid, client *types.Client) (int, []byte, error) {
+	switch cmd[0] {
+	case TOKEN:
+		return TokenBin(writer, cmd, client)
+	case SUBSCRIBE:
+		return SubscribeBin(writer, cmd, grids, client)
+	case MODE:
+		return ModeBin(writer, cmd, client)
+	case HELLO:
+		return HelloBin(writer, cmd, client)
+	case OFFSET:
+		return OffsetBin(cmd, client)
+	case COMPRESS:
+		return CompressBin(writer, cmd, client)
+	case BATCH:
+		return BatchBin(cmd, grids, client)
+	}
+	return 1, cmd[:1], errors.New("unknown control command")
+}
+
+func TokenBin(writer io.Writer, cmd []byte, client *types.Client) (int, []byte, error) {
+	if cmdLen(cmd, 2) {
+		return 0, nil, nil
+	}
+	length := 2 + int(cmd[1])
+	if cmdLen(cmd, length) {
+		return 0, nil, nil
+	}
+	team, found := client.Authenticate(string(cmd[2:length]))
+	if len(team) > 0xff {
+		team = team[:0xff]
+	}
+	_, err := writer.Write(append([]byte{cmd[0], byte(len(team))}, team...))
+	if err == nil && !found {
+		err = errors.New("unknown token")
+	}
+	return length, cmd[:length], err
+}
+
+func tokenCmd(token []byte, client *types.Client, writer io.Writer) error {
+	team, found := client.Authenticate(string(token))
+	if !found {
+		_, err := writer.Write([]byte("TEAM\n"))
 		if err != nil {
 			return err
 		}
-		if !found { // a request for the current color
-			c, err := grids[ICON_GRID_INDEX].Get(x, y)
-			if err != nil {
-				return err
+		return errors.New("unknown token")
+	}
+	_, err := writer.Write([]byte(fmt.Sprintf("TEAM %s\n", team)))
+	return err
+}
+
+func TextCmd(cmd []byte, grids [types.GRID_AMOUNT]*types.Grid, client *types.Client, writer io.Writer) (err error) {
+	if bytes.Compare(cmd, HELP_COMMAND) == 0 {
+		_, err = writer.Write(helpCmd(grids))
+	} else if rest, found := bytes.CutPrefix(cmd, HELLO_COMMAND_START); found {
+		err = helloCmd(rest, client, writer)
+	} else if rest, found := bytes.CutPrefix(cmd, OFFSET_COMMAND_START); found {
+		err = offsetCmd(rest, client)
+	} else if rest, found := bytes.CutPrefix(cmd, COMPRESS_COMMAND_START); found {
+		err = compressCmd(rest, client, writer)
+	} else if bytes.Equal(cmd, INFO_COMMAND) {
+		_, err = writer.Write(infoCmd(grids))
+	} else if bytes.Equal(cmd, PALETTE_COMMAND) {
+		_, err = writer.Write(paletteCmd(grids))
+	} else if bytes.Compare(cmd, SIZE_COMMAND) == 0 {
+		_, err = writer.Write(sizeCmd(grids[0], false))
+	} else if bytes.Compare(cmd, SIZE_ICON_COMMAND) == 0 {
+		_, err = writer.Write(sizeCmd(grids[1], false))
+	} else if rest, found := bytes.CutPrefix(cmd, PX_COMMAND_START); found {
+		err = pxCmd(rest, grids[MAIN_GRID_INDEX], client, writer)
+	} else if rest, found := bytes.CutPrefix(cmd, PX_ICON_COMMAND_START); found {
+		err = pxCmd(rest, grids[ICON_GRID_INDEX], client, writer)
+	} else if rest, found := bytes.CutPrefix(cmd, TEXT_COMMAND_START); found {
+		err = textCmd(rest, grids[MAIN_GRID_INDEX], client)
+	} else if rest, found := bytes.CutPrefix(cmd, TEXT_ICON_COMMAND_START); found {
+		err = textCmd(rest, grids[ICON_GRID_INDEX], client)
+	} else if rest, found := bytes.CutPrefix(cmd, TOKEN_COMMAND_START); found {
+		err = tokenCmd(rest, client, writer)
+	} else if rest, found := bytes.CutPrefix(cmd, MODE_COMMAND); found {
+		err = modeCmd(rest, client, writer)
+	} else if bytes.Equal(cmd, SUBSCRIBE_COMMAND) {
+		if !client.Has(FEATURE_SUBSCRIBE) {
+			return ErrNotNegotiated
+		}
+		client.Subscribe(writer, false)
+		for _, grid := range grids {
+			if _, err = writer.Write(sizeCmd(grid, true)); err != nil {
+				break
 			}
-			_, err = writer.Write([]byte(fmt.Sprintf("PX %d %d %s\n", x, y, PxToHex(c))))
-		} else {
-			err = grids[ICON_GRID_INDEX].Set(uint32(x)<<16|uint32(y), color)
 		}
+	} else {
+		err = errors.New("unknown command")
 	}
 	return
 }
+
+// SubscribeBin makes the client receive the new size of a canvas whenever it
+// gets resized, it replies with itself and the current sizes.
+func SubscribeBin(writer io.Writer, cmd []byte, grids [types.GRID_AMOUNT]*types.Grid, client *types.Client) (int, []byte, error) {
+	if !client.Has(FEATURE_SUBSCRIBE) {
+		return 1, cmd[:1], ErrNotNegotiated
+	}
+	client.Subscribe(writer, true)
+	reply := []byte{cmd[0]}
+	for _, grid := range grids {
+		reply = append(reply, sizeReply(grid)...)
+	}
+	_, err := writer.Write(reply)
+	return 1, cmd[:1], err
+}
+
+// ModeBin sets the blend mode of the client, it replies with itself and the
+// current mode.
+func ModeBin(writer io.Writer, cmd []byte, client *types.Client) (int, []byte, error) {
+	if cmdLen(cmd, 2) {
+		return 0, nil, nil
+	}
+	var err error
+	if mode := types.BlendMode(cmd[1]); mode < types.BLEND_AMOUNT {
+		client.SetBlendMode(mode)
+	} else {
+		err = errors.New("unknown blend mode")
+	}
+	if _, werr := writer.Write([]byte{cmd[0], byte(client.BlendMode())}); werr != nil {
+		return 2, cmd[:2], werr
+	}
+	return 2, cmd[:2], err
+}
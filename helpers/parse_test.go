@@ -0,0 +1,87 @@
+package helpers
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/itepastra/flutties/types"
+)
+
+func TestUnknownCanvasBin(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     []byte
+		unknown bool
+		advance int
+	}{
+		{"rgb565 on a known canvas", []byte{SET_RGB565 | 1, 0, 0, 0, 0, 0, 0}, false, 0},
+		{"rgb565", []byte{SET_RGB565 | 3, 0, 0, 0, 0, 0, 0, SET_RGB}, true, 7},
+		{"rgba16", append([]byte{SET_RGBA16 | 5}, make([]byte, 12)...), true, 13},
+		{"partial rgba16", []byte{SET_RGBA16 | 5, 0, 0}, true, 0},
+		{"rgb", []byte{SET_RGB | 15, 0, 0, 0, 0, 0, 0, 0}, true, 8},
+		{"index", []byte{SET_INDEX | 2, 0, 0, 0, 0, 7, SET_INDEX}, true, 6},
+		{"partial index", []byte{SET_INDEX | 2, 0, 0, 0, 0}, true, 0},
+		{"index on a known canvas", []byte{SET_INDEX, 0, 0, 0, 0, 7}, false, 0},
+		{"control commands have no canvas", []byte{HELLO, 1, 0}, false, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if unknown := UnknownCanvas(test.cmd[0]); unknown != test.unknown {
+				t.Fatalf("UnknownCanvas(%#x) = %t", test.cmd[0], unknown)
+			}
+			if !test.unknown {
+				return
+			}
+			advance, token, err := UnknownCanvasBin(&bytes.Buffer{}, test.cmd)
+			if advance != test.advance || len(token) != test.advance {
+				t.Errorf("skipped %d bytes, not %d", advance, test.advance)
+			}
+			if advance > 0 && !errors.Is(err, ErrUnknownCanvas) {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
+
+// TestProbeCanvasses asks for the size of every canvas id, the ones that
+// don't exist are 0 by 0.
+func TestProbeCanvasses(t *testing.T) {
+	var reply bytes.Buffer
+	advance, _, err := UnknownCanvasBin(&reply, []byte{SIZE | 9, GET_PIXEL_VALUE | 9})
+	if advance != 1 || err != nil {
+		t.Fatalf("skipped %d bytes with error %v", advance, err)
+	}
+	if want := []byte{SIZE | 9, 0, 0, 0, 0}; !bytes.Equal(reply.Bytes(), want) {
+		t.Errorf("replied %x, not %x", reply.Bytes(), want)
+	}
+
+	reply.Reset()
+	advance, _, err = UnknownCanvasBin(&reply, []byte{GET_PIXEL_VALUE | 9, 0, 0, 0, 0})
+	if advance != 5 || !errors.Is(err, ErrUnknownCanvas) || reply.Len() != 0 {
+		t.Errorf("skipped %d bytes with error %v and replied %x", advance, err, reply.Bytes())
+	}
+}
+
+func TestHelpPalette(t *testing.T) {
+	grids := [types.GRID_AMOUNT]*types.Grid{types.NewGrid(2, 2, 0, 0), types.NewGrid(2, 2, 0, 1)}
+	client := types.NewRegistry().Add("a", types.PROTOCOL_TCP, nil)
+	var reply bytes.Buffer
+	if err := TextCmd(HELP_COMMAND, grids, client, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(reply.Bytes(), []byte("palette")) {
+		t.Errorf("the help explains palettes without any:\n%s", reply.Bytes())
+	}
+
+	if err := grids[1].Palette.Set([]uint32{types.RGBA(0xff, 0, 0, 0xff)}); err != nil {
+		t.Fatal(err)
+	}
+	reply.Reset()
+	if err := TextCmd(HELP_COMMAND, grids, client, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(reply.Bytes(), paletteHelp) || !bytes.HasSuffix(reply.Bytes(), []byte("PALETTE 1 ff0000\n")) {
+		t.Errorf("the help doesn't list the palette:\n%s", reply.Bytes())
+	}
+}
@@ -0,0 +1,34 @@
+package helpers
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/itepastra/flutties/helpers/draw"
+	"github.com/itepastra/flutties/types"
+)
+
+// textCmd handles TEXT <x> <y> <color> <message>, which writes message with
+// the built in font with its top left corner at x, y. Every pixel of the text
+// counts against the pixel limit.
+func textCmd(rest []byte, grid *types.Grid, client *types.Client) error {
+	parts := bytes.SplitN(rest, []byte{' '}, 4)
+	if len(parts) != 4 {
+		return errors.New("TEXT needs x, y, a color and a message")
+	}
+	x, err := strconv.ParseUint(string(parts[0]), 10, 16)
+	if err != nil {
+		return err
+	}
+	y, err := strconv.ParseUint(string(parts[1]), 10, 16)
+	if err != nil {
+		return err
+	}
+	color, err := parseHex(string(parts[2]))
+	if err != nil {
+		return err
+	}
+	xy := client.Translate(uint32(y)<<16 | uint32(x))
+	return draw.NewPen(grid, color, client).Text(int(xy&0xffff), int(xy>>16), string(parts[3]), 1, nil)
+}
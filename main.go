@@ -3,61 +3,163 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/zlib"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"image/jpeg"
 	"io"
 	"log"
-	"mime/multipart"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
-	"net/textproto"
+	"os"
+	"os/signal"
 	"strconv"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/a-h/templ"
 	"github.com/gorilla/websocket"
 	"github.com/itepastra/flutties/helpers"
-	"github.com/itepastra/flutties/helpers/multi"
+	"github.com/itepastra/flutties/helpers/access"
+	"github.com/itepastra/flutties/helpers/config"
 	"github.com/itepastra/flutties/pages"
 	"github.com/itepastra/flutties/types"
 )
 
 const (
-	TIMEOUT_DELAY       = 30 * time.Second
 	BOUNDARY_STRING     = "thisisaboundary"
 	BOUNDARY_STRING_ICO = "thisisicoboundary"
-	JPEG_UPDATE_TIMER   = 25 * time.Millisecond
-	JPEG_PING_TIMER     = 25 * time.Second
-	ICON_UPDATE_TIMER   = 25 * time.Millisecond
-	ICON_WIDTH          = 32
-	ICON_HEIGHT         = 32
-	STATS_UPDATE_TIMER  = 200 * time.Millisecond
+	LEADERBOARD_SIZE    = 10
+	// MAX_COMMAND_SIZE fits the largest BATCH frame
+	MAX_COMMAND_SIZE = 1 << 20
+	// HOST_IDLE is how long the shared client of an address that draws
+	// through http is kept after its last request
+	HOST_IDLE = 10 * time.Minute
 )
 
 var upgrader = websocket.Upgrader{}
+var defaults = config.Default()
 var (
-	pixelflut_port          = flag.String("pixelflut", ":7791", "the port where the pixelflut is accessible internally")
-	pixelflut_port_external = flag.String("pixelflut_ext", "55282", "the port where the pixelflut is accessible externally, used for the webpage")
-	web_port                = flag.String("web", ":7792", "the address the website should listen on")
-	width                   = flag.Uint("width", 800, "the canvas width")
-	height                  = flag.Uint("height", 600, "the canvas height")
+	config_path             = flag.String("config", "", "a json config file, the other flags override its values")
+	pixelflut_port          = flag.String("pixelflut", defaults.Listeners.Pixelflut, "the port where the pixelflut is accessible internally")
+	pixelflut_port_external = flag.String("pixelflut_ext", defaults.Listeners.PixelflutExternal, "the port where the pixelflut is accessible externally, used for the webpage")
+	web_port                = flag.String("web", defaults.Listeners.Web, "the address the website should listen on")
+	width                   = flag.Uint("width", uint(defaults.Canvases.Main.Width), "the canvas width")
+	height                  = flag.Uint("height", uint(defaults.Canvases.Main.Height), "the canvas height")
+	pixelflut_acl_path      = flag.String("pixelflut_acl", "", "the access list file for the pixelflut listener, reloaded on SIGHUP")
+	web_acl_path            = flag.String("web_acl", "", "the access list file for drawing from the website, reloaded on SIGHUP")
+	protected_path          = flag.String("protected", "", "a json file with the regions of the canvasses clients can't write to")
+	teams_path              = flag.String("teams", "", "a json file with the team tokens and the zones of the canvasses they own")
 )
 
 var (
-	currentClients uint
+	clients = types.NewRegistry()
+	conf    config.Config
 )
 
+// loadConfig reads the config file and applies the flags that were set on top.
+func loadConfig() (config.Config, error) {
+	conf := config.Default()
+	if *config_path != "" {
+		var err error
+		conf, err = config.Load(*config_path)
+		if err != nil {
+			return conf, err
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "pixelflut":
+			conf.Listeners.Pixelflut = *pixelflut_port
+		case "pixelflut_ext":
+			conf.Listeners.PixelflutExternal = *pixelflut_port_external
+		case "web":
+			conf.Listeners.Web = *web_port
+		case "width":
+			conf.Canvases.Main.Width = int(*width)
+		case "height":
+			conf.Canvases.Main.Height = int(*height)
+		case "pixelflut_acl":
+			conf.Files.PixelflutACL = *pixelflut_acl_path
+		case "web_acl":
+			conf.Files.WebACL = *web_acl_path
+		case "protected":
+			conf.Files.Protected = *protected_path
+		case "teams":
+			conf.Files.Teams = *teams_path
+		case "admin_token":
+			conf.AdminToken = *admin_token
+		}
+	})
+	return conf, conf.Validate()
+}
+
+// timeoutReader disconnects clients that don't send anything for too long.
+// Subscribed clients only have to listen, so they can stay idle.
+type timeoutReader struct {
+	conn    net.Conn
+	client  *types.Client
+	timeout time.Duration
+}
+
+func (t timeoutReader) Read(p []byte) (int, error) {
+	if _, _, subscribed := t.client.Subscription(); subscribed {
+		t.conn.SetReadDeadline(time.Time{})
+	} else if t.timeout > 0 {
+		t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+	}
+	return t.conn.Read(p)
+}
+
+// acceptClient checks if a new pixelflut connection is within the limits.
+func acceptClient(conn net.Conn) bool {
+	limits := conf.Limits
+	if limits.MaxClients > 0 && clients.Count(types.PROTOCOL_TCP) >= limits.MaxClients {
+		return false
+	}
+	if limits.MaxClientsPerIP > 0 && clients.CountHost(types.PROTOCOL_TCP, conn.RemoteAddr().String()) >= limits.MaxClientsPerIP {
+		return false
+	}
+	return true
+}
+
+// allowed checks the remote address of a connection or request against list.
+func allowed(list *access.List, remote string) bool {
+	addr, err := access.RemoteAddr(remote)
+	if err != nil {
+		return false
+	}
+	return list.Allowed(addr)
+}
+
+func reloadOnHangup(lists ...*access.List) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		for _, list := range lists {
+			if err := list.Reload(); err != nil {
+				log.Printf("could not reload access list, keeping the old one: %s", err)
+			}
+		}
+	}
+}
+
 const (
 	INFO            byte = helpers.INFO
 	SIZE                 = helpers.SIZE
+	CONTROL              = helpers.CONTROL
+	SET_RGB565           = helpers.SET_RGB565
+	SET_INDEX            = helpers.SET_INDEX
 	GET_PIXEL_VALUE      = helpers.GET_PIXEL_VALUE
 	SET_GRAYSCALE        = helpers.SET_GRAYSCALE
 	SET_HALF_RGBA        = helpers.SET_HALF_RGBA
 	SET_RGB              = helpers.SET_RGB
 	SET_RGBA             = helpers.SET_RGBA
+	SET_RGBA16           = helpers.SET_RGBA16
 	SOUND_LOOP           = helpers.SOUND_LOOP
 	SOUND_ONCE           = helpers.SOUND_ONCE
 	H                    = helpers.H
@@ -81,52 +183,87 @@ func CheckMinLength(data []byte, targetLen int) (advance int, token []byte, err
 	}
 }
 
-func createScanCommands(grids [types.GRID_AMOUNT]*types.Grid, conn io.Writer) func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+// compressedInput is how the input of a connection continues after it
+// switched to compression, rest was already read after the command.
+type compressedInput struct {
+	method string
+	rest   []byte
+}
+
+// createScanCommands returns the split function that handles the commands of
+// client. When the client switches to compressed input scanning stops, and
+// next says how to continue.
+func createScanCommands(grids [types.GRID_AMOUNT]*types.Grid, client *types.Client, conn io.Writer, next *compressedInput) func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
 		if len(data) == 0 {
 			return 0, nil, nil
 		}
+		// the commands that address a canvas by the lower 4 bits would index
+		// past the canvasses
+		if helpers.UnknownCanvas(data[0]) {
+			advance, token, err = helpers.UnknownCanvasBin(conn, data)
+			if err != nil {
+				client.Errors.Add(1)
+			}
+			return advance, token, nil
+		}
 
 		switch data[0] & 0xf0 {
 		case INFO:
-			return helpers.HelpBin(conn, data)
+			advance, token, err = helpers.InfoBin(conn, data, grids)
 		case SIZE:
-			return helpers.InfoBin(conn, data, grids)
+			advance, token, err = helpers.SizeBin(conn, data, grids)
+		case CONTROL:
+			advance, token, err = helpers.ControlBin(conn, data, grids, client)
+		case SET_RGB565:
+			advance, token, err = helpers.SetRGB565Bin(data, grids, client)
+		case SET_INDEX:
+			advance, token, err = helpers.SetIndexBin(data, grids, client)
 		case GET_PIXEL_VALUE:
-			return helpers.GetPixelBin(conn, data, grids)
+			advance, token, err = helpers.GetPixelBin(conn, data, grids, client)
 		case SET_GRAYSCALE:
-			return helpers.SetGrayscaleBin(data, grids)
+			advance, token, err = helpers.SetGrayscaleBin(data, grids, client)
 		case SET_HALF_RGBA:
-			return helpers.SetHalfRGBABin(data, grids)
+			advance, token, err = helpers.SetHalfRGBABin(data, grids, client)
 		case SET_RGB:
-			return helpers.SetRGBBin(data, grids)
+			advance, token, err = helpers.SetRGBBin(data, grids, client)
 		case SET_RGBA:
-			return helpers.SetRGBABin(data, grids)
-		case H & 0xf0:
-		case P & 0xf0:
+			advance, token, err = helpers.SetRGBABin(data, grids, client)
+		case SET_RGBA16:
+			advance, token, err = helpers.SetRGBA16Bin(data, grids, client)
+		case H & 0xf0, P & 0xf0:
 			if i := bytes.IndexByte(data, '\n'); i >= 0 {
 				dropped := dropCR(data[:i])
-				helpers.TextCmd(dropped, grids, conn)
-				return i + i, dropped, nil
+				err = helpers.TextCmd(dropped, grids, client, conn)
+				advance, token = i+1, dropped
 			}
+		default:
+			// skip bytes that can't start a command, like stray newlines
+			advance, token = 1, data[:1]
 		}
 
-		// If we're at EOF, we have a final, non-terminated line. Return it.
-		if atEOF {
-			return 0, nil, nil
+		// errors in a single command should not end the connection
+		if err != nil {
+			client.Errors.Add(1)
 		}
-		// Request more data.
-		return 0, nil, nil
+		if method, ok := client.TakeCompression(); ok {
+			*next = compressedInput{method, bytes.Clone(data[advance:])}
+			return advance, token, bufio.ErrFinalToken
+		}
+		// If we're at EOF with an incomplete command we can't do anything with it,
+		// otherwise we request more data.
+		return advance, token, nil
 	}
 }
 
 func handleConnection(conn net.Conn, grids [types.GRID_AMOUNT]*types.Grid) {
-	currentClients = currentClients + 1
+	if banned(conn.RemoteAddr().String()) {
+		conn.Close()
+		return
+	}
+	client := clients.Add(conn.RemoteAddr().String(), types.PROTOCOL_TCP, conn)
 	defer func() {
-		currentClients = currentClients - 1
+		clients.Remove(client)
 		conn.Close()
 	}()
 	defer func() {
@@ -134,123 +271,260 @@ func handleConnection(conn net.Conn, grids [types.GRID_AMOUNT]*types.Grid) {
 			log.Println("Recovered in handleConnection: ", r)
 		}
 	}()
-	c := bufio.NewScanner(conn)
-	c.Split(createScanCommands(grids, conn))
+	reader := client.Reader(timeoutReader{conn, client, conf.Limits.Timeout.D()})
+	writer := client.Writer(conn)
 	for {
-		if !c.Scan() {
-			if c.Err() == nil {
-				return
-			}
-			log.Printf("Got error from scanner %e", c.Err())
+		next := compressedInput{}
+		c := bufio.NewScanner(reader)
+		c.Buffer(make([]byte, 0, 64*1024), MAX_COMMAND_SIZE)
+		c.Split(createScanCommands(grids, client, writer, &next))
+		for c.Scan() {
+		}
+		if err := c.Err(); err != nil {
+			client.Errors.Add(1)
+			log.Printf("connection %s had an error %s, disconnecting", client.Addr, err)
+			return
 		}
+		if next.method == "" {
+			return
+		}
+		// the rest of the input is compressed, it is fed into a new scanner
 		var err error
+		reader, err = decompress(next.method, io.MultiReader(bytes.NewReader(next.rest), reader))
 		if err != nil {
-			log.Printf("connection %v had an error %e while sending, disconnecting", conn, err)
+			client.Errors.Add(1)
+			log.Printf("connection %s sent invalid compressed data %s, disconnecting", client.Addr, err)
 			return
 		}
 	}
+}
 
+func decompress(method string, r io.Reader) (io.Reader, error) {
+	switch method {
+	case "flate":
+		return flate.NewReader(r), nil
+	case "zlib":
+		return zlib.NewReader(r)
+	}
+	return nil, fmt.Errorf("unknown compression method %q", method)
 }
 
-func frameGenerator(grid *types.Grid, multiWriter multi.MapWriter, ch <-chan struct{}) {
-	multipartWriter := multipart.NewWriter(multiWriter)
-	multipartWriter.SetBoundary(BOUNDARY_STRING)
-	header := make(textproto.MIMEHeader)
-	header.Add("Content-Type", "image/jpeg")
-	for {
-		select {
-		case <-ch:
-			writer, _ := multipartWriter.CreatePart(header)
-			jpeg.Encode(writer, grid, &jpeg.Options{Quality: 75})
-		}
+// parseColor turns a rrggbb hex string into an opaque grid color.
+func parseColor(hex string) (uint32, error) {
+	color, err := strconv.ParseUint(hex, 16, 24)
+	if err != nil {
+		return 0, err
 	}
+	return uint32((color&0xff)<<16 | color&0xff00 | (color&0xff0000)>>16 | (0xff << 24)), nil
 }
 
-func frameTimer(grid *types.Grid, ch chan<- struct{}) {
-	prev := grid.ChangedPixels
-	for {
-		ch <- struct{}{}
-		time.Sleep(JPEG_UPDATE_TIMER)
-		if prev == grid.ChangedPixels {
-			// nothing happened since last update. since no new pixels
-			for prev == grid.ChangedPixels && time.Since(grid.Modified) < JPEG_PING_TIMER {
-				time.Sleep(JPEG_UPDATE_TIMER)
-			}
-			grid.Modified = time.Now()
-		} else {
-			grid.Modified = time.Now()
+type clientSummary struct {
+	Id     uint64                    `json:"id"`
+	Pixels [types.GRID_AMOUNT]uint64 `json:"p"`
+	Age    int64                     `json:"a"`
+}
+
+type statsMessage struct {
+	Clients int             `json:"c"`
+	Viewers int             `json:"w"`
+	Pixels  uint64          `json:"p"`
+	Icon    uint64          `json:"i"`
+	List    []clientSummary `json:"l"`
+	Board   []types.Score   `json:"b"`
+}
+
+type resizeEvent struct {
+	Canvas byte `json:"canvas"`
+	Width  int  `json:"w"`
+	Height int  `json:"h"`
+}
+
+// resizeMessage is sent to the viewers instead of the stats when a canvas
+// got resized.
+type resizeMessage struct {
+	Resize resizeEvent `json:"resize"`
+}
+
+// statsCache holds the latest encoded stats, so they don't have to be
+// collected for every viewer.
+var statsCache atomic.Pointer[[]byte]
+
+// newStatsMessage collects the public stats, client addresses are left out
+// since everyone can see these.
+func newStatsMessage(grid *types.Grid, icoGrid *types.Grid) statsMessage {
+	msg := statsMessage{
+		Viewers: clients.Count(types.PROTOCOL_WS),
+		Pixels:  atomic.LoadUint64(&grid.ChangedPixels),
+		Icon:    atomic.LoadUint64(&icoGrid.ChangedPixels),
+		List:    []clientSummary{},
+	}
+	for _, stats := range clients.Stats() {
+		if stats.Protocol != types.PROTOCOL_TCP {
+			continue
 		}
+		msg.List = append(msg.List, clientSummary{
+			Id:     stats.Id,
+			Pixels: stats.Pixels,
+			Age:    int64(time.Since(stats.Connected).Seconds()),
+		})
 	}
+	msg.Clients = len(msg.List)
+	msg.Board = clients.Leaderboard(grid, LEADERBOARD_SIZE)
+	return msg
 }
 
-func drawShape(grid *types.Grid, dc drawcall) error {
-	color_a, err := strconv.ParseInt(dc.Color, 16, 32)
+func updateStats(grid *types.Grid, icoGrid *types.Grid) {
+	data, err := json.Marshal(newStatsMessage(grid, icoGrid))
 	if err != nil {
-		return err
+		log.Printf("could not encode stats: %s", err)
+		return
 	}
-	color := uint32((color_a&0xff)<<16 | color_a&0xff00 | (color_a&0xff0000)>>16 | (0xff << 24))
-	for i := -dc.Size; i <= dc.Size; i += 1 {
-		if dc.Y+i < 0 {
-			continue
-		}
-		if dc.Y+i >= grid.SizeY {
-			break
-		}
-		for j := -dc.Size; j <= dc.Size; j += 1 {
-			if dc.X+j < 0 {
-				continue
-			}
-			if dc.X+j >= grid.SizeX {
-				break
-			}
-			if i*i+j*j >= dc.Size*dc.Size {
-				continue
-			}
-			err := grid.SetExact(uint32(i+dc.Y)<<16|uint32(j+dc.X), color)
-			if err != nil {
-				return err
-			}
-		}
+	statsCache.Store(&data)
+}
+
+func statsTimer(grid *types.Grid, icoGrid *types.Grid) {
+	for {
+		time.Sleep(conf.Stream.StatsTimer.D())
+		updateStats(grid, icoGrid)
 	}
-	return nil
 }
 
-type drawcall struct {
-	X     int
-	Y     int
-	Color string
-	Size  int
+// hostTimer forgets the shared clients of addresses that stopped drawing
+// through http.
+func hostTimer() {
+	for {
+		time.Sleep(time.Minute)
+		clients.ExpireHosts(HOST_IDLE)
+	}
 }
 
 func main() {
 	flag.Parse()
+	var err error
+	conf, err = loadConfig()
+	if err != nil {
+		log.Fatalf("invalid configuration: %s", err)
+	}
+	clients.PixelRate = int64(conf.Limits.PixelRate)
+
+	pixelflutACL, err := access.NewList(conf.Files.PixelflutACL)
+	if err != nil {
+		log.Fatalf("could not load pixelflut access list: %s", err)
+	}
+	webACL, err := access.NewList(conf.Files.WebACL)
+	if err != nil {
+		log.Fatalf("could not load web access list: %s", err)
+	}
+	go reloadOnHangup(pixelflutACL, webACL)
 
-	multiWriter := multi.NewMapWriter()
+	grid, err := newCanvas(conf.Canvases.Main.Background, uint16(conf.Canvases.Main.Width), uint16(conf.Canvases.Main.Height), 0)
+	if err != nil {
+		log.Fatalf("could not create the main canvas: %s", err)
+	}
+	icoGrid, err := newCanvas(conf.Canvases.Icon.Background, uint16(conf.Canvases.Icon.Width), uint16(conf.Canvases.Icon.Height), 1)
+	if err != nil {
+		log.Fatalf("could not create the icon canvas: %s", err)
+	}
+	grids := [types.GRID_AMOUNT]*types.Grid{grid, icoGrid}
+	backgrounds := [types.GRID_AMOUNT]*background{}
+	scheduled := [types.GRID_AMOUNT]*overlays{}
+	for i, canvas := range []config.Canvas{conf.Canvases.Main, conf.Canvases.Icon} {
+		backgrounds[i] = newBackground(canvas.Background)
+		scheduled[i] = newOverlays(grids[i])
+		for _, overlay := range canvas.Overlays {
+			if err := scheduled[i].Add(overlay); err != nil {
+				log.Fatalf("invalid overlay %q: %s", overlay.Text+overlay.Image, err)
+			}
+		}
+		if canvas.Deep {
+			grids[i].EnableDeep()
+		}
+		colors := make([]uint32, len(canvas.Palette))
+		for j, hex := range canvas.Palette {
+			if colors[j], err = parseColor(hex); err != nil {
+				log.Fatalf("invalid palette color %q: %s", hex, err)
+			}
+		}
+		if err := grids[i].Palette.Set(colors); err != nil {
+			log.Fatalf("invalid palette: %s", err)
+		}
+		for _, layer := range canvas.Layers {
+			info := types.LayerInfo{Name: layer.Name, Opacity: 0xff, Visible: !layer.Hidden}
+			if layer.Opacity != nil {
+				info.Opacity = *layer.Opacity
+			}
+			if _, err := grids[i].AddLayer(info); err != nil {
+				log.Fatalf("invalid layer %q: %s", layer.Name, err)
+			}
+		}
+		if err := grids[i].SetWriteLayer(canvas.WriteLayer); err != nil {
+			log.Fatalf("invalid write layer %q: %s", canvas.WriteLayer, err)
+		}
+		if canvas.Decay.Mode != "" {
+			go decayTimer(grids[i], canvas.Decay, backgrounds[i])
+		}
+	}
+	go overlayTimer(scheduled)
+	if conf.Persistence.Dir != "" {
+		loadGrids(grids)
+		go persistTimer(grids)
+		go saveOnExit(grids)
+	}
 
-	grid := types.NewGridRandom(uint16(*width), uint16(*height), 0)
-	icoGrid := types.NewGridRandom(ICON_WIDTH, ICON_HEIGHT, 1)
+	if conf.Files.Protected != "" {
+		rects, err := types.LoadCanvasRects(conf.Files.Protected)
+		if err != nil {
+			log.Fatalf("could not load protected regions: %s", err)
+		}
+		for _, rect := range rects {
+			if rect.Canvas >= types.GRID_AMOUNT {
+				log.Fatalf("protected region %+v is on unknown canvas %d", rect.Rect, rect.Canvas)
+			}
+			grids[rect.Canvas].Protected.Add(rect.Rect)
+		}
+	}
+	if conf.Files.Teams != "" {
+		teams, err := types.LoadTeamsFile(conf.Files.Teams)
+		if err != nil {
+			log.Fatalf("could not load teams: %s", err)
+		}
+		clients.Teams.Set(teams.Teams)
+		for _, grid := range grids {
+			grid.Zones.SetExclusive(teams.Exclusive)
+		}
+		for _, zone := range teams.Zones {
+			if zone.Canvas >= types.GRID_AMOUNT {
+				log.Fatalf("zone %+v is on unknown canvas %d", zone.Zone, zone.Canvas)
+			}
+			grids[zone.Canvas].Zones.Add(zone.Zone)
+		}
+	}
 
-	ln, err := net.Listen("tcp", *pixelflut_port)
+	ln, err := net.Listen("tcp", conf.Listeners.Pixelflut)
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
-	log.Printf("pixelflut started listening at %s, the grid has size (%d, %d)", *pixelflut_port, *width, *height)
+	log.Printf("pixelflut started listening at %s, the grid has size (%d, %d)", conf.Listeners.Pixelflut, conf.Canvases.Main.Width, conf.Canvases.Main.Height)
 	go func() {
 		for {
 			conn, err := ln.Accept()
 			if err != nil {
-				log.Printf("rip connection: %e", err)
+				log.Printf("rip connection: %s", err)
+				continue
+			}
+			if !allowed(pixelflutACL, conn.RemoteAddr().String()) || !acceptClient(conn) {
+				conn.Close()
+				continue
 			}
-			go handleConnection(conn, [types.GRID_AMOUNT]*types.Grid{&grid, &icoGrid})
+			go handleConnection(conn, grids)
 		}
 	}()
 
-	ch := make(chan struct{})
+	updateStats(grid, icoGrid)
+	go statsTimer(grid, icoGrid)
+	go hostTimer()
 
-	go frameGenerator(&grid, multiWriter, ch)
-	go frameTimer(&grid, ch)
-
-	http.Handle("/", templ.Handler(pages.Index(*pixelflut_port_external)))
+	http.Handle("/", templ.Handler(pages.Index(conf.Listeners.PixelflutExternal, conf.Canvases.Main.Palette)))
 	http.HandleFunc("/icoflut.js", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "text/javascript")
 		http.ServeFile(w, r, "./static/icoflut.js")
@@ -267,12 +541,12 @@ func main() {
 			if err != nil {
 				return
 			}
-			jpeg.Encode(writer, &icoGrid, &jpeg.Options{Quality: 90})
-			time.Sleep(ICON_UPDATE_TIMER)
-			if time.Since(icoGrid.Modified) > ICON_UPDATE_TIMER {
+			jpeg.Encode(writer, icoGrid.Composited(), &jpeg.Options{Quality: conf.Stream.IconQuality})
+			time.Sleep(conf.Stream.IconTimer.D())
+			if time.Since(icoGrid.Modified) > conf.Stream.IconTimer.D() {
 				mt := icoGrid.Modified
 				for mt == icoGrid.Modified {
-					time.Sleep(ICON_UPDATE_TIMER)
+					time.Sleep(conf.Stream.IconTimer.D())
 				}
 			}
 		}
@@ -284,108 +558,57 @@ func main() {
 			return
 		}
 		defer c.Close()
-		// keep writing the stats to the websocket
+		client := clients.Add(r.RemoteAddr, types.PROTOCOL_WS, c)
+		defer clients.Remove(client)
+		// keep writing the stats to the websocket, and tell the viewer when a
+		// canvas changes size
 		go func() {
+			generations := [types.GRID_AMOUNT]uint32{}
+			for i, grid := range grids {
+				generations[i] = grid.Generation()
+			}
 			for {
+				for i, grid := range grids {
+					if generation := grid.Generation(); generation != generations[i] {
+						generations[i] = generation
+						sizeX, sizeY := grid.Size()
+						if c.WriteJSON(resizeMessage{resizeEvent{grid.Index, sizeX, sizeY}}) != nil {
+							return
+						}
+					}
+				}
 				writer, err := c.NextWriter(websocket.TextMessage)
 				if err != nil {
 					return
 				}
-				writer.Write([]byte(fmt.Sprintf(`{"c":%d,"p":%d,"i":%d}`, currentClients, grid.ChangedPixels, icoGrid.ChangedPixels)))
-				time.Sleep(STATS_UPDATE_TIMER)
+				client.Writer(writer).Write(*statsCache.Load())
+				writer.Close()
+				time.Sleep(conf.Stream.StatsTimer.D())
 			}
 		}()
-		// receive draw calls from the websocket
+		// the viewer only reads, drawing goes through the api
 		for {
 			_, data, err := c.ReadMessage()
 			if err != nil {
 				return
 			}
-			drawCall := drawcall{}
-			err = json.Unmarshal(data, &drawCall)
-			if err != nil {
-				log.Println(err)
+			client.BytesIn.Add(uint64(len(data)))
+			if banned(client.Addr) {
 				return
 			}
-			drawShape(&grid, drawCall)
 		}
 	})
 	http.HandleFunc("/icon", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "image/jpeg")
 		w.Header().Set("Cache-Control", "no-store")
 		w.Header().Set("Connection", "close")
-		jpeg.Encode(w, &icoGrid, &jpeg.Options{Quality: 90})
+		jpeg.Encode(w, icoGrid.Composited(), &jpeg.Options{Quality: conf.Stream.IconQuality})
 	})
-	http.HandleFunc("/grid", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set(
-			"Content-Type",
-			fmt.Sprintf("multipart/x-mixed-replace;boundary=%s", BOUNDARY_STRING),
-		)
-		w.Header().Set("Cache-Control", "no-store")
-		w.Header().Set("Connection", "close")
+	http.HandleFunc("/grid", gridHandler(grid))
 
-		multiWriter.Add(w)
-		ch <- struct{}{}
-		ch <- struct{}{} // NOTE: firefox does not load the bottom rows correctly without this
-		<-r.Context().Done()
-		multiWriter.Remove(w)
-	})
+	registerAPI(grids, backgrounds, webACL)
 
-	http.HandleFunc("/color/{x}/{y}/{color}/{size}", func(w http.ResponseWriter, r *http.Request) {
-		xStr := r.PathValue("x")
-		x, err := strconv.Atoi(xStr)
-		if err != nil {
-			log.Println("x parse err")
-			return
-		}
-		yStr := r.PathValue("y")
-		y, err := strconv.Atoi(yStr)
-		if err != nil {
-			log.Println("y parse err")
-			return
-		}
-		colorStr := r.PathValue("color")
-		color, err := strconv.ParseInt(colorStr, 16, 32)
-		if err != nil {
-			log.Println("color parse err")
-			return
-		}
-		color = (color&0xff)<<16 | color&0xff00 | (color&0xff0000)>>16 | (0xff << 24)
-		log.Println(color)
-
-		sizeStr := r.PathValue("size")
-		size, err := strconv.Atoi(sizeStr)
-		if err != nil {
-			log.Println("size parse err")
-			return
-		}
-
-		for i := -size; i <= size; i += 1 {
-			if y+i < 0 {
-				continue
-			}
-			if y+i >= grid.SizeY {
-				break
-			}
-			for j := -size; j <= size; j += 1 {
-				if x+j < 0 {
-					continue
-				}
-				if x+j >= grid.SizeX {
-					break
-				}
-				if i*i+j*j >= size*size {
-					continue
-				}
-				err = grid.SetExact(uint32(i+y)<<16|uint32(j+x), uint32(color))
-				if err != nil {
-					log.Println("oop")
-					return
-				}
-			}
-		}
-
-	})
+	registerAdmin(grids, backgrounds, scheduled)
 
-	log.Fatal(http.ListenAndServe(*web_port, nil))
+	log.Fatal(http.ListenAndServe(conf.Listeners.Web, nil))
 }
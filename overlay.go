@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	imagedraw "image/draw"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itepastra/flutties/helpers/config"
+	"github.com/itepastra/flutties/helpers/draw"
+	"github.com/itepastra/flutties/types"
+)
+
+// overlayCanvas lets pens draw on the image of an overlay, pixels are
+// replaced instead of blended.
+type overlayCanvas struct {
+	*image.NRGBA
+}
+
+func (o overlayCanvas) Get(x uint16, y uint16) (uint32, error) {
+	c := o.NRGBAAt(int(x), int(y))
+	return types.RGBA(c.R, c.G, c.B, c.A), nil
+}
+
+func (o overlayCanvas) Set(xy uint32, c uint32, _ *types.Client) error {
+	r, g, b, a := types.Channels(c)
+	o.SetNRGBA(int(xy&0xffff), int(xy>>16), color.NRGBA{r, g, b, a})
+	return nil
+}
+
+type scheduledOverlay struct {
+	config.Overlay
+	image image.Image
+}
+
+// overlays are the scheduled overlays of a canvas.
+type overlays struct {
+	grid *types.Grid
+	list []scheduledOverlay
+	lock sync.Mutex
+}
+
+func newOverlays(grid *types.Grid) *overlays {
+	return &overlays{grid: grid}
+}
+
+// Add schedules overlay, its image is loaded right away.
+func (o *overlays) Add(overlay config.Overlay) error {
+	if err := overlay.Validate(); err != nil {
+		return err
+	}
+	scheduled := scheduledOverlay{Overlay: overlay}
+	if overlay.Image != "" {
+		img, err := types.LoadImage(overlay.Image)
+		if err != nil {
+			return err
+		}
+		scheduled.image = img
+	}
+	o.lock.Lock()
+	o.list = append(o.list, scheduled)
+	o.lock.Unlock()
+	o.render(time.Now())
+	return nil
+}
+
+// Remove removes the overlay at index i, it returns false if there is none.
+func (o *overlays) Remove(i int) bool {
+	o.lock.Lock()
+	if i < 0 || i >= len(o.list) {
+		o.lock.Unlock()
+		return false
+	}
+	o.list = append(o.list[:i], o.list[i+1:]...)
+	o.lock.Unlock()
+	o.render(time.Now())
+	return true
+}
+
+func (o *overlays) List() []config.Overlay {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	list := make([]config.Overlay, len(o.list))
+	for i, scheduled := range o.list {
+		list[i] = scheduled.Overlay
+	}
+	return list
+}
+
+// countdown formats the time until target as hours, minutes and seconds.
+func countdown(target time.Time, now time.Time) string {
+	left := max(target.Sub(now).Round(time.Second), 0)
+	return fmt.Sprintf("%02d:%02d:%02d", int(left.Hours()), int(left.Minutes())%60, int(left.Seconds())%60)
+}
+
+// render draws the overlays that are shown at now on the overlay of the grid.
+func (o *overlays) render(now time.Time) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	img := image.NewNRGBA(o.grid.Bounds())
+	shown := false
+	for _, overlay := range o.list {
+		if !overlay.Start.IsZero() && now.Before(overlay.Start) || !overlay.End.IsZero() && !now.Before(overlay.End) {
+			continue
+		}
+		shown = true
+		scale := max(overlay.Scale, 1)
+		if overlay.image != nil {
+			bounds := overlay.image.Bounds()
+			scaled := scaleImage(overlay.image, bounds.Dx()*scale, bounds.Dy()*scale)
+			imagedraw.Draw(img, scaled.Rect.Add(image.Pt(overlay.X, overlay.Y)), scaled, image.Point{}, imagedraw.Over)
+			continue
+		}
+		text := strings.NewReplacer(
+			"{countdown}", countdown(overlay.Countdown, now),
+			"{port}", conf.Listeners.PixelflutExternal,
+		).Replace(overlay.Text)
+		if err := drawOverlayText(img, overlay.Overlay, text); err != nil {
+			log.Printf("could not draw overlay %q: %s", overlay.Text, err)
+		}
+	}
+	if !shown {
+		img = nil
+	}
+	o.grid.Overlay.Store(img)
+}
+
+func drawOverlayText(img *image.NRGBA, overlay config.Overlay, text string) error {
+	hex := overlay.Color
+	if hex == "" {
+		hex = "ffffff"
+	}
+	color, err := parseColor(hex)
+	if err != nil {
+		return err
+	}
+	var background *draw.Pen
+	if overlay.Background != "" {
+		c, err := parseColor(overlay.Background)
+		if err != nil {
+			return err
+		}
+		background = draw.NewPen(overlayCanvas{img}, c, nil)
+	}
+	return draw.NewPen(overlayCanvas{img}, color, nil).Text(overlay.X, overlay.Y, text, overlay.Scale, background)
+}
+
+// overlayTimer draws the overlays every second, so countdowns keep running
+// and overlays show up and disappear on time.
+func overlayTimer(list [types.GRID_AMOUNT]*overlays) {
+	for {
+		for _, overlays := range list {
+			overlays.render(time.Now())
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/itepastra/flutties/types"
+)
+
+func gridPath(grid *types.Grid) string {
+	return filepath.Join(conf.Persistence.Dir, fmt.Sprintf("canvas-%d.png", grid.Index))
+}
+
+// layerPath is where a layer of grid is saved, next to the grid itself.
+func layerPath(grid *types.Grid, name string) string {
+	return filepath.Join(conf.Persistence.Dir, fmt.Sprintf("canvas-%d-layer-%s.png", grid.Index, name))
+}
+
+type persisted interface {
+	Load(path string) error
+	Save(path string) error
+}
+
+// persistedParts are the grid and its layers, with the paths they are saved
+// at.
+func persistedParts(grid *types.Grid) map[string]persisted {
+	parts := map[string]persisted{gridPath(grid): grid}
+	for _, info := range grid.Layers() {
+		if layer, err := grid.Layer(info.Name); err == nil {
+			parts[layerPath(grid, info.Name)] = layer
+		}
+	}
+	return parts
+}
+
+func loadGrids(grids [types.GRID_AMOUNT]*types.Grid) {
+	for _, grid := range grids {
+		for path, part := range persistedParts(grid) {
+			err := part.Load(path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				log.Printf("could not load %s of canvas %d: %s", path, grid.Index, err)
+				continue
+			}
+			log.Printf("loaded canvas %d from %s", grid.Index, path)
+		}
+	}
+}
+
+func saveGrids(grids [types.GRID_AMOUNT]*types.Grid) {
+	for _, grid := range grids {
+		for path, part := range persistedParts(grid) {
+			if err := part.Save(path); err != nil {
+				log.Printf("could not save %s of canvas %d: %s", path, grid.Index, err)
+			}
+		}
+	}
+}
+
+func persistTimer(grids [types.GRID_AMOUNT]*types.Grid) {
+	for {
+		time.Sleep(conf.Persistence.Interval.D())
+		saveGrids(grids)
+	}
+}
+
+// saveOnExit saves the grids one last time when the server gets stopped.
+func saveOnExit(grids [types.GRID_AMOUNT]*types.Grid) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	saveGrids(grids)
+	os.Exit(0)
+}
@@ -4,54 +4,108 @@ function nString(value) {
 }
 
 var isDrawing = false;
-var ws = undefined;
+// where the current stroke, line or rectangle started and was last drawn
+var start = undefined;
+var last = undefined;
 
-function DrawAtCursor(e) {
-	let ratioX = e.target.naturalWidth / e.target.offsetWidth;
-	let ratioY = e.target.naturalHeight / e.target.offsetHeight;
+function CursorPosition(e) {
+	let grid = document.getElementById("grid");
+	let ratioX = grid.naturalWidth / grid.offsetWidth;
+	let ratioY = grid.naturalHeight / grid.offsetHeight;
 
-	let domX = e.x + window.scrollX - e.target.offsetLeft;
-	let domY = e.y + window.scrollY - e.target.offsetTop;
+	let domX = e.x + window.scrollX - grid.offsetLeft;
+	let domY = e.y + window.scrollY - grid.offsetTop;
 
-	let imgX = Math.floor(domX * ratioX);
-	let imgY = Math.floor(domY * ratioY);
+	return { x: Math.floor(domX * ratioX), y: Math.floor(domY * ratioY) };
+}
+
+// tools are the shapes of the drawing api that the tools of the webpage use
+const tools = {
+	brush: { shape: "line", erase: false },
+	eraser: { shape: "line", erase: true },
+	line: { shape: "line", erase: false },
+	rect: { shape: "rect", erase: false },
+	fill: { shape: "fill", erase: false },
+	text: { shape: "text", erase: false },
+};
 
+async function Draw(tool, from, to) {
 	let color = document.querySelector("input[name=color]:checked").value;
 	let size = document.querySelector("input[name=size]:checked").value;
-
-	if (typeof ws !== 'undefined') {
-		if (!imgX || !imgY) {
-			console.log("waaa")
-			return
-		}
-		ws.send(JSON.stringify({ x: imgX, y: imgY, color: color, size: +size }))
-	} else {
-		console.log("websocket needs to connect first")
+	let alpha = document.querySelector("input[name=alpha]").value;
+	let text = document.querySelector("input[name=text]").value;
+	let body = { x: to.x, y: to.y, x2: from.x, y2: from.y, color: color, alpha: +alpha, size: +size, erase: tools[tool].erase, text: text, scale: +size };
+	let response = await fetch("/api/canvas/0/" + tools[tool].shape, { method: "POST", body: JSON.stringify(body) });
+	if (!response.ok) {
+		console.log("could not draw", (await response.json()).error);
 	}
 }
 
+function SelectedTool() {
+	return document.querySelector("input[name=tool]:checked").value;
+}
+
 async function StartDrawing(e) {
 	isDrawing = true;
-	DrawAtCursor(e)
+	start = last = CursorPosition(e);
+	let tool = SelectedTool();
+	if (tool == "brush" || tool == "eraser" || tool == "fill" || tool == "text") {
+		Draw(tool, last, last)
+	}
 }
 
-function StopDrawing() {
+function StopDrawing(e) {
+	if (!isDrawing) { return; }
 	isDrawing = false;
+	let tool = SelectedTool();
+	if (tool == "line" || tool == "rect") {
+		Draw(tool, start, CursorPosition(e))
+	}
 }
 
 onpointermove = async function (e) {
 	if (!isDrawing) { return; }
-	DrawAtCursor(e)
+	let tool = SelectedTool();
+	if (tool != "brush" && tool != "eraser") { return; }
+	let position = CursorPosition(e);
+	if (position.x == last.x && position.y == last.y) { return; }
+	Draw(tool, last, position)
+	last = position;
 };
 
+async function UploadImage(e) {
+	e.preventDefault();
+	let result = document.getElementById("uploadResult");
+	let response = await fetch("/api/canvas/0/image", { method: "POST", body: new FormData(e.target) });
+	let body = await response.json();
+	result.innerText = response.ok ? body.pixels + " pixels placed" : body.error;
+}
+
+function UpdateLeaderboard(board, scores) {
+	board.replaceChildren(...scores.map(function (score, i) {
+		let row = document.createElement("tr");
+		for (const value of [i + 1, score.n, nString(score.o), nString(score.w)]) {
+			let cell = document.createElement("td");
+			cell.innerText = value;
+			row.appendChild(cell);
+		}
+		if (score.t) {
+			row.style.fontWeight = "bold";
+		}
+		return row;
+	}));
+}
+
 window.onload = function () {
 	var favicon = document.getElementById("favicon");
+	var grid = document.getElementById("grid");
 
 	var client = document.getElementById("clientCounter");
 	var pixel = document.getElementById("pixelCounter");
 	var pixelAvg = document.getElementById("pixelCounterAvg");
 	var icon = document.getElementById("iconCounter");
 	var iconAvg = document.getElementById("iconCounterAvg");
+	var board = document.getElementById("leaderboard");
 
 	var pixelQueue = [];
 	var iconQueue = [];
@@ -63,7 +117,6 @@ window.onload = function () {
 
 	const socket = new WebSocket("/icoflut");
 	const stats = new WebSocket("/stats");
-	ws = stats;
 
 	stats.onopen = function () {
 		console.log('Connected to stats.');
@@ -78,6 +131,13 @@ window.onload = function () {
 
 	stats.onmessage = function (event) {
 		const obj = JSON.parse(event.data);
+		if (obj.resize) {
+			// the stream keeps the old size, so it has to be restarted
+			if (obj.resize.canvas == 0) {
+				grid.src = "/grid?" + Date.now();
+			}
+			return
+		}
 		client.innerText = nString(obj.c)
 
 		pixel.innerText = nString(obj.p)
@@ -89,6 +149,8 @@ window.onload = function () {
 		iconQueue.push(obj.i)
 		var old = iconQueue.shift()
 		iconAvg.innerText = nString(obj.i - old)
+
+		UpdateLeaderboard(board, obj.b)
 	}
 
 	socket.onopen = function () {
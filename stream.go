@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/itepastra/flutties/helpers/multi"
+	"github.com/itepastra/flutties/types"
+)
+
+// MAX_STREAM_TIERS is how many different tiers of /grid are encoded at once,
+// besides the default one
+const MAX_STREAM_TIERS = 16
+
+// The values that the tiers of /grid requests are rounded to, so viewers
+// that ask for about the same share their frames.
+var (
+	streamQualities = []int{10, 25, 50, 75, 100}
+	streamScales    = []int{10, 25, 50, 75, 100}
+	streamFps       = []int{1, 2, 5, 10, 25}
+)
+
+// nearest returns the value of steps that is closest to value.
+func nearest(steps []int, value int) int {
+	best := steps[0]
+	for _, step := range steps {
+		if abs(step-value) < abs(best-value) {
+			best = step
+		}
+	}
+	return best
+}
+
+// atMost returns the largest value of steps that isn't above value, or the
+// smallest one.
+func atMost(steps []int, value int) int {
+	best := steps[0]
+	for _, step := range steps {
+		if step <= value {
+			best = step
+		}
+	}
+	return best
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// streamTier is a quality, size and frame rate of /grid, all viewers of a
+// tier share its frames.
+type streamTier struct {
+	quality int
+	// scale is the size of the frames in percent of the canvas
+	scale int
+	// fps is how many frames are sent every second at most, 0 is as often
+	// as stream.jpeg_timer allows
+	fps int
+}
+
+// defaultStreamTier is the tier of /grid without query parameters.
+func defaultStreamTier() streamTier {
+	return streamTier{quality: conf.Stream.JpegQuality, scale: 100}
+}
+
+// parseStreamTier reads the tier of a /grid request, what is missing is the
+// default of the configuration. The values are rounded to streamQualities,
+// streamScales and streamFps, so there are only a few tiers.
+func parseStreamTier(r *http.Request) (streamTier, error) {
+	tier := defaultStreamTier()
+	if r.URL.Query().Has("quality") {
+		quality, err := queryInt(r, "quality", 0)
+		if err != nil || quality < 1 || quality > 100 {
+			return tier, errors.New("quality should be between 1 and 100")
+		}
+		tier.quality = nearest(streamQualities, quality)
+	}
+	if value := r.URL.Query().Get("scale"); value != "" {
+		scale, err := strconv.ParseFloat(value, 64)
+		if err != nil || scale <= 0 || scale > 1 {
+			return tier, errors.New("scale should be above 0 and at most 1")
+		}
+		tier.scale = nearest(streamScales, int(math.Round(scale*100)))
+	}
+	fps, err := queryInt(r, "fps", 0)
+	if err != nil || fps < 0 {
+		return tier, errors.New("fps can't be negative")
+	}
+	if fps > 0 {
+		tier.fps = atMost(streamFps, fps)
+	}
+	if time.Duration(tier.fps)*conf.Stream.JpegTimer.D() >= time.Second {
+		tier.fps = 0
+	}
+	return tier, nil
+}
+
+// interval is the time between frames of the tier.
+func (t streamTier) interval() time.Duration {
+	if t.fps == 0 {
+		return conf.Stream.JpegTimer.D()
+	}
+	return time.Second / time.Duration(t.fps)
+}
+
+// frame is what the tier shows of grid.
+func (t streamTier) frame(grid *types.Grid) image.Image {
+	sizeX, sizeY := grid.Size()
+	return grid.Frame(sizeX*t.scale/100, sizeY*t.scale/100)
+}
+
+// changes grows whenever the pixels, the layers or the overlay of grid
+// change.
+func changes(grid *types.Grid) uint64 {
+	return atomic.LoadUint64(&grid.ChangedPixels) + grid.LayersChanged() + grid.Overlay.Version()
+}
+
+// frameGenerator sends the frames of tier to its viewers until the last one
+// leaves. A frame is sent when the canvas changed, every stream.jpeg_ping
+// when it doesn't and when a viewer joins.
+func frameGenerator(grid *types.Grid, tier streamTier, writer multi.MapWriter, joined <-chan struct{}, done <-chan struct{}) {
+	multipartWriter := multipart.NewWriter(writer)
+	multipartWriter.SetBoundary(BOUNDARY_STRING)
+	header := make(textproto.MIMEHeader)
+	header.Add("Content-Type", "image/jpeg")
+	prev, sent := changes(grid), time.Now()
+	send := func() {
+		part, _ := multipartWriter.CreatePart(header)
+		jpeg.Encode(part, tier.frame(grid), &jpeg.Options{Quality: tier.quality})
+		sent = time.Now()
+	}
+
+	ticker := time.NewTicker(tier.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-joined:
+			prev = changes(grid)
+			send()
+			send() // NOTE: firefox does not load the bottom rows correctly without this
+		case <-ticker.C:
+			if current := changes(grid); current != prev || time.Since(sent) >= conf.Stream.JpegPing.D() {
+				prev = current
+				send()
+			}
+		}
+	}
+}
+
+// flushWriter sends everything that is written right away, small frames
+// would otherwise wait in the buffer of the response.
+type flushWriter struct {
+	http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.ResponseWriter.Write(p)
+	http.NewResponseController(f.ResponseWriter).Flush()
+	return n, err
+}
+
+// gridHandler streams the main canvas as a multipart jpeg, in the tier that
+// is asked for with the quality, scale and fps query parameters.
+func gridHandler(grid *types.Grid) http.HandlerFunc {
+	start := func(tier streamTier, writer multi.MapWriter, joined <-chan struct{}, done <-chan struct{}) {
+		frameGenerator(grid, tier, writer, joined, done)
+	}
+	tiers := multi.NewGroup(MAX_STREAM_TIERS, start)
+	// the default tier has its own group, so other tiers can't fill it up
+	defaults := multi.NewGroup(0, start)
+	return func(w http.ResponseWriter, r *http.Request) {
+		tier, err := parseStreamTier(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set(
+			"Content-Type",
+			fmt.Sprintf("multipart/x-mixed-replace;boundary=%s", BOUNDARY_STRING),
+		)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Connection", "close")
+
+		group := tiers
+		if tier == defaultStreamTier() {
+			group = defaults
+		}
+		writer := flushWriter{w}
+		if err := group.Add(tier, writer); err != nil {
+			w.Header().Del("Content-Type")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		<-r.Context().Done()
+		group.Remove(tier, writer)
+	}
+}
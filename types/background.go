@@ -0,0 +1,66 @@
+package types
+
+import "image"
+
+// Randomize fills the grid with random noise.
+func (g *Grid) Randomize() {
+	b := g.buf.Load()
+	for i := range b.cells {
+		b.setCell(i, randomColor())
+	}
+}
+
+// mix goes from a to b in steps, per channel.
+func mix(a uint32, b uint32, step int, steps int) uint32 {
+	if steps <= 0 {
+		return a
+	}
+	var c uint32
+	for shift := 0; shift < 32; shift += 8 {
+		from := int(a >> shift & 0xff)
+		to := int(b >> shift & 0xff)
+		c |= uint32(from+(to-from)*step/steps) << shift
+	}
+	return c
+}
+
+// Gradient fills the grid with a gradient from top at the top to bottom at
+// the bottom.
+func (g *Grid) Gradient(top uint32, bottom uint32) {
+	b := g.buf.Load()
+	for y := 0; y < b.sizeY; y++ {
+		c := mix(top, bottom, y, b.sizeY-1)
+		for x := 0; x < b.sizeX; x++ {
+			b.setCell(y*b.sizeX+x, c)
+		}
+	}
+}
+
+// Checkerboard fills the grid with squares of size pixels in two colors.
+func (g *Grid) Checkerboard(even uint32, odd uint32, size int) {
+	b := g.buf.Load()
+	for y := 0; y < b.sizeY; y++ {
+		for x := 0; x < b.sizeX; x++ {
+			if (x/size+y/size)%2 == 0 {
+				b.setCell(y*b.sizeX+x, even)
+			} else {
+				b.setCell(y*b.sizeX+x, odd)
+			}
+		}
+	}
+}
+
+// DrawScaled stretches img over the whole grid, using the nearest pixel of
+// img for every cell.
+func (g *Grid) DrawScaled(img image.Image) {
+	b := g.buf.Load()
+	bounds := img.Bounds()
+	for y := 0; y < b.sizeY; y++ {
+		sy := bounds.Min.Y + y*bounds.Dy()/b.sizeY
+		for x := 0; x < b.sizeX; x++ {
+			sx := bounds.Min.X + x*bounds.Dx()/b.sizeX
+			r, gr, bl, _ := img.At(sx, sy).RGBA()
+			b.setCell(y*b.sizeX+x, uint32(r>>8)|uint32(gr>>8)<<8|uint32(bl>>8)<<16|0xff<<24)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package types
+
+import "sync/atomic"
+
+// Pixel is a single write of a batch, with the coordinates packed like the
+// xy of Set and a color with 16 bits per channel like SetRGBA64.
+type Pixel struct {
+	XY    uint32
+	Color uint64
+}
+
+// SetBatch blends all pixels into the grid with the blend mode of client.
+// Unlike Set it checks the bounds, the rate limit and whether the grid is
+// frozen only once for the whole batch, a batch that doesn't fit is
+// rejected completely. Pixels in protected regions and zones of other teams
+// are skipped and don't count against the rate limit. It returns the amount
+// of pixels that were written.
+func (g *Grid) SetBatch(pixels []Pixel, client *Client) (int, error) {
+	if len(pixels) == 0 {
+		return 0, nil
+	}
+	b := g.buf.Load()
+	var maxX, maxY uint32
+	for _, p := range pixels {
+		maxX, maxY = max(maxX, p.XY&0xffff), max(maxY, p.XY>>16)
+	}
+	if int(maxX) >= b.sizeX || int(maxY) >= b.sizeY {
+		return 0, ErrOutOfBounds
+	}
+	if client != nil {
+		if g.Frozen() {
+			return 0, ErrFrozen
+		}
+		allowed := make([]Pixel, 0, len(pixels))
+		for _, p := range pixels {
+			x, y := int(p.XY&0xffff), int(p.XY>>16)
+			if g.Protected.Contains(x, y) {
+				atomic.AddUint64(&g.Rejected, 1)
+				continue
+			}
+			if !g.Zones.Allowed(x, y, client.Team()) {
+				continue
+			}
+			allowed = append(allowed, p)
+		}
+		if len(allowed) > 0 && !client.allow(int64(len(allowed))) {
+			return 0, ErrRateLimited
+		}
+		pixels = allowed
+	}
+
+	mode := client.BlendMode()
+	layer := g.writeLayer(client)
+	written := 0
+	for _, p := range pixels {
+		idx := int(p.XY>>16)*b.sizeX + int(p.XY&0xffff)
+		if layer != nil {
+			layer.set(idx, Narrow(p.Color), mode, g.Palette)
+		} else if b.deep != nil {
+			b.blendDeep(idx, p.Color, mode)
+			g.snap(b, idx)
+		} else {
+			b.cells[idx] = mode.Blend(b.cells[idx], Narrow(p.Color))
+			g.snap(b, idx)
+		}
+		g.Owners.record(b, idx, client)
+		written++
+	}
+
+	atomic.AddUint64(&g.ChangedPixels, uint64(written))
+	if client != nil {
+		client.Pixels[g.Index].Add(uint64(written))
+	}
+	return written, nil
+}
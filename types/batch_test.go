@@ -0,0 +1,59 @@
+package types
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestBatchRateLimit checks that a batch that is rejected for being larger
+// than the pixel limit doesn't use it up.
+func TestBatchRateLimit(t *testing.T) {
+	grid := NewGrid(8, 8, 0, 0)
+	registry := NewRegistry()
+	registry.PixelRate = 10
+	client := registry.Add("a", PROTOCOL_TCP, nil)
+
+	pixels := make([]Pixel, 20)
+	for i := range pixels {
+		pixels[i] = Pixel{XY: uint32(i % 8), Color: Widen(0xffffffff)}
+	}
+	if _, err := grid.SetBatch(pixels, client); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("a batch larger than the limit got %v", err)
+	}
+	if err := grid.Set(0, 0xffffffff, client); err != nil {
+		t.Fatalf("a single pixel after the rejected batch got %v", err)
+	}
+	if written, err := grid.SetBatch(pixels[:9], client); written != 9 || err != nil {
+		t.Fatalf("the rest of the limit wrote %d pixels with %v", written, err)
+	}
+	if err := grid.Set(0, 0xffffffff, client); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("a pixel over the limit got %v", err)
+	}
+}
+
+// TestBatchProtectedNotCharged checks that skipped pixels of a batch don't
+// count against the pixel limit, like they don't with Set.
+func TestBatchProtectedNotCharged(t *testing.T) {
+	grid := NewGrid(8, 8, 0, 0)
+	grid.Protected.Add(Rect{X: 0, Y: 0, W: 8, H: 1})
+	registry := NewRegistry()
+	registry.PixelRate = 10
+	client := registry.Add("a", PROTOCOL_TCP, nil)
+
+	pixels := make([]Pixel, 10)
+	for i := range pixels {
+		pixels[i] = Pixel{XY: uint32(i % 8), Color: Widen(0xffffffff)}
+	}
+	pixels[9].XY = 1 << 16
+	if written, err := grid.SetBatch(pixels, client); written != 1 || err != nil {
+		t.Fatalf("wrote %d pixels with %v", written, err)
+	}
+	if written, err := grid.SetBatch(pixels[:9:9], client); written != 0 || err != nil {
+		t.Fatalf("a batch of protected pixels wrote %d pixels with %v", written, err)
+	}
+	for i := 0; i < 9; i++ {
+		if err := grid.Set(uint32(2+i/8)<<16|uint32(i%8), 0xffffffff, client); err != nil {
+			t.Fatalf("pixel %d of the limit got %v", i, err)
+		}
+	}
+}
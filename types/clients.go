@@ -0,0 +1,395 @@
+package types
+
+import (
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	PROTOCOL_TCP  = "tcp"
+	PROTOCOL_WS   = "ws"
+	PROTOCOL_HTTP = "http"
+)
+
+// Client holds the bookkeeping for a single connection to the server.
+// All counters are safe to update from multiple goroutines.
+type Client struct {
+	Id        uint64
+	Addr      string
+	Protocol  string
+	Connected time.Time
+	BytesIn   atomic.Uint64
+	BytesOut  atomic.Uint64
+	Pixels    [GRID_AMOUNT]atomic.Uint64
+	Errors    atomic.Uint64
+	// Owned is the amount of pixels per canvas that were last written by
+	// this client.
+	Owned    [GRID_AMOUNT]atomic.Int64
+	gone     atomic.Bool
+	rate     int64
+	window   atomic.Int64
+	written  atomic.Int64
+	team     atomic.Pointer[string]
+	blend    atomic.Uint32
+	version  atomic.Uint32
+	features atomic.Uint32
+	offset   atomic.Uint32
+	compress atomic.Pointer[string]
+	registry *Registry
+	closer   io.Closer
+	// used is the unix time of the last request of a client from Host
+	used atomic.Int64
+	// writing makes sure messages pushed to the client don't end up in the
+	// middle of a reply.
+	writing      sync.Mutex
+	subscription atomic.Pointer[subscription]
+}
+
+// subscription is where a client wants to receive pushed messages, and
+// whether it wants them in the binary protocol.
+type subscription struct {
+	writer io.Writer
+	binary bool
+}
+
+// ClientStats is a point in time copy of the counters of a Client.
+type ClientStats struct {
+	Id        uint64              `json:"id"`
+	Addr      string              `json:"addr"`
+	Protocol  string              `json:"protocol"`
+	Connected time.Time           `json:"connected"`
+	BytesIn   uint64              `json:"bytes_in"`
+	BytesOut  uint64              `json:"bytes_out"`
+	Pixels    [GRID_AMOUNT]uint64 `json:"pixels"`
+	Owned     [GRID_AMOUNT]int64  `json:"owned"`
+	Errors    uint64              `json:"errors"`
+	Team      string              `json:"team"`
+	Gone      bool                `json:"disconnected"`
+}
+
+func (c *Client) Stats() ClientStats {
+	stats := ClientStats{
+		Id:        c.Id,
+		Addr:      c.Addr,
+		Protocol:  c.Protocol,
+		Connected: c.Connected,
+		BytesIn:   c.BytesIn.Load(),
+		BytesOut:  c.BytesOut.Load(),
+		Errors:    c.Errors.Load(),
+		Team:      c.Team(),
+		Gone:      c.gone.Load(),
+	}
+	for i := range c.Pixels {
+		stats.Pixels[i] = c.Pixels[i].Load()
+		stats.Owned[i] = c.Owned[i].Load()
+	}
+	return stats
+}
+
+// Team returns the team the client authenticated as, or "" if it didn't.
+func (c *Client) Team() string {
+	if team := c.team.Load(); team != nil {
+		return *team
+	}
+	return ""
+}
+
+// Authenticate makes the client part of the team token belongs to.
+func (c *Client) Authenticate(token string) (string, bool) {
+	if c.registry == nil {
+		return "", false
+	}
+	team, found := c.registry.Teams.Team(token)
+	if found {
+		c.team.Store(&team)
+	}
+	return team, found
+}
+
+// BlendMode returns how the writes of the client are blended, a nil client
+// is the server itself which uses alpha blending.
+func (c *Client) BlendMode() BlendMode {
+	if c == nil {
+		return BLEND_ALPHA
+	}
+	return BlendMode(c.blend.Load())
+}
+
+func (c *Client) SetBlendMode(mode BlendMode) {
+	c.blend.Store(uint32(mode))
+}
+
+// Negotiate stores the protocol version and features the client agreed on
+// with the server.
+func (c *Client) Negotiate(version uint32, features uint32) {
+	c.version.Store(version)
+	c.features.Store(features)
+}
+
+// Version is the protocol version the client negotiated, 0 if it didn't.
+func (c *Client) Version() uint32 {
+	return c.version.Load()
+}
+
+// Has reports whether the client negotiated all of features.
+func (c *Client) Has(features uint32) bool {
+	return c.features.Load()&features == features
+}
+
+// SetOffset moves the origin of the coordinates the client sends to (x, y).
+func (c *Client) SetOffset(x uint16, y uint16) {
+	c.offset.Store(uint32(y)<<16 | uint32(x))
+}
+
+// Translate adds the offset of the client to xy.
+func (c *Client) Translate(xy uint32) uint32 {
+	offset := c.offset.Load()
+	return (xy>>16+offset>>16)<<16 | (xy+offset)&0xffff
+}
+
+// Compress makes the input of the client switch to the compression method
+// after the command that is being handled.
+func (c *Client) Compress(method string) {
+	c.compress.Store(&method)
+}
+
+// TakeCompression returns the compression method the input of the client
+// should switch to, ok is false if it shouldn't switch.
+func (c *Client) TakeCompression() (method string, ok bool) {
+	if method := c.compress.Swap(nil); method != nil {
+		return *method, true
+	}
+	return "", false
+}
+
+// allow counts pixels against the rate limit of the client, it returns
+// false when they don't fit in what is left of this second. Pixels that
+// aren't allowed don't count, so a batch that is too large doesn't block the
+// writes after it.
+func (c *Client) allow(pixels int64) bool {
+	if c.rate <= 0 {
+		return true
+	}
+	now := time.Now().Unix()
+	if window := c.window.Load(); window != now && c.window.CompareAndSwap(window, now) {
+		c.written.Store(0)
+	}
+	for {
+		written := c.written.Load()
+		if written+pixels > c.rate {
+			return false
+		}
+		if c.written.CompareAndSwap(written, written+pixels) {
+			return true
+		}
+	}
+}
+
+// Close closes the underlying connection of the client.
+func (c *Client) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Uint64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(uint64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w      io.Writer
+	client *Client
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	c.client.writing.Lock()
+	n, err := c.w.Write(p)
+	c.client.writing.Unlock()
+	c.client.BytesOut.Add(uint64(n))
+	return n, err
+}
+
+// Reader wraps r so that everything read from it is added to BytesIn.
+func (c *Client) Reader(r io.Reader) io.Reader {
+	return countingReader{r, &c.BytesIn}
+}
+
+// Writer wraps w so that everything written to it is added to BytesOut.
+func (c *Client) Writer(w io.Writer) io.Writer {
+	return countingWriter{w, c}
+}
+
+// Subscribe makes the client receive pushed messages on w, which should come
+// from Writer.
+func (c *Client) Subscribe(w io.Writer, binary bool) {
+	c.subscription.Store(&subscription{w, binary})
+}
+
+// Subscription returns where the client wants to receive pushed messages, ok
+// is false if it didn't subscribe.
+func (c *Client) Subscription() (w io.Writer, binary bool, ok bool) {
+	if sub := c.subscription.Load(); sub != nil {
+		return sub.writer, sub.binary, true
+	}
+	return nil, false, false
+}
+
+// Registry keeps track of all connected clients and the teams they can
+// authenticate as.
+type Registry struct {
+	Teams *Teams
+	// PixelRate is the amount of pixels new clients can write every second,
+	// 0 means unlimited.
+	PixelRate int64
+	lock      sync.RWMutex
+	clients   map[uint64]*Client
+	// hosts are the clients that are shared by everything from a host
+	hosts  map[string]*Client
+	lastId atomic.Uint64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		Teams:   NewTeams(),
+		clients: make(map[uint64]*Client),
+		hosts:   make(map[string]*Client),
+	}
+}
+
+func (r *Registry) newClient(addr string, protocol string, closer io.Closer) *Client {
+	return &Client{
+		Id:        r.lastId.Add(1),
+		Addr:      addr,
+		Protocol:  protocol,
+		Connected: time.Now(),
+		registry:  r,
+		rate:      r.PixelRate,
+		closer:    closer,
+	}
+}
+
+// Add registers a new client, closer is used when the client gets closed.
+func (r *Registry) Add(addr string, protocol string, closer io.Closer) *Client {
+	client := r.newClient(addr, protocol, closer)
+	r.lock.Lock()
+	r.clients[client.Id] = client
+	r.lock.Unlock()
+	return client
+}
+
+// Host returns the client of protocol that every request from the host of
+// addr shares, so they share the pixel limit and their score. It is
+// registered by the first request and stays until ExpireHosts removes it.
+func (r *Registry) Host(addr string, protocol string) *Client {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	key := protocol + " " + host
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	client, found := r.hosts[key]
+	if !found {
+		client = r.newClient(host, protocol, nil)
+		r.clients[client.Id] = client
+		r.hosts[key] = client
+	}
+	client.used.Store(time.Now().Unix())
+	return client
+}
+
+// ExpireHosts removes the clients from Host that had no requests for idle,
+// it returns how many were removed.
+func (r *Registry) ExpireHosts(idle time.Duration) (count int) {
+	oldest := time.Now().Add(-idle).Unix()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for key, client := range r.hosts {
+		if client.used.Load() >= oldest {
+			continue
+		}
+		client.gone.Store(true)
+		delete(r.hosts, key)
+		delete(r.clients, client.Id)
+		count++
+	}
+	return
+}
+
+func (r *Registry) Remove(client *Client) {
+	client.gone.Store(true)
+	r.lock.Lock()
+	delete(r.clients, client.Id)
+	r.lock.Unlock()
+}
+
+func (r *Registry) Get(id uint64) (*Client, bool) {
+	r.lock.RLock()
+	client, ok := r.clients[id]
+	r.lock.RUnlock()
+	return client, ok
+}
+
+// Clients returns all connected clients.
+func (r *Registry) Clients() []*Client {
+	r.lock.RLock()
+	clients := make([]*Client, 0, len(r.clients))
+	for _, client := range r.clients {
+		clients = append(clients, client)
+	}
+	r.lock.RUnlock()
+	return clients
+}
+
+// Count returns the amount of connected clients using the given protocol.
+func (r *Registry) Count(protocol string) (count int) {
+	r.lock.RLock()
+	for _, client := range r.clients {
+		if client.Protocol == protocol {
+			count++
+		}
+	}
+	r.lock.RUnlock()
+	return
+}
+
+// CountHost returns the amount of connected clients using the given protocol
+// from the same host as addr.
+func (r *Registry) CountHost(protocol string, addr string) (count int) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0
+	}
+	r.lock.RLock()
+	for _, client := range r.clients {
+		if other, _, err := net.SplitHostPort(client.Addr); err == nil && other == host && client.Protocol == protocol {
+			count++
+		}
+	}
+	r.lock.RUnlock()
+	return
+}
+
+// Stats returns the stats of all connected clients, ordered by id.
+func (r *Registry) Stats() []ClientStats {
+	r.lock.RLock()
+	stats := make([]ClientStats, 0, len(r.clients))
+	for _, client := range r.clients {
+		stats = append(stats, client.Stats())
+	}
+	r.lock.RUnlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Id < stats[j].Id })
+	return stats
+}
@@ -0,0 +1,28 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpireHosts(t *testing.T) {
+	registry := NewRegistry()
+	client := registry.Host("10.0.0.1:1234", PROTOCOL_HTTP)
+	if registry.Host("10.0.0.1:5678", PROTOCOL_HTTP) != client {
+		t.Fatal("two requests from a host got different clients")
+	}
+	if count := registry.ExpireHosts(time.Minute); count != 0 {
+		t.Fatalf("expired %d clients that were just used", count)
+	}
+
+	client.used.Store(time.Now().Add(-2 * time.Minute).Unix())
+	if count := registry.ExpireHosts(time.Minute); count != 1 {
+		t.Fatalf("expired %d clients, not 1", count)
+	}
+	if len(registry.Clients()) != 0 || !client.Stats().Gone {
+		t.Error("the expired client is still registered")
+	}
+	if registry.Host("10.0.0.1:1234", PROTOCOL_HTTP) == client {
+		t.Error("the host got the expired client back")
+	}
+}
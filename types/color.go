@@ -0,0 +1,90 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Colors are stored as 0xAABBGGRR, so in memory the bytes are in RGBA order.
+// The cells of a grid are always opaque, the alpha of a color that gets
+// written says how much it covers the pixel below.
+
+// RGBA packs the channels of a color.
+func RGBA(r byte, g byte, b byte, a byte) uint32 {
+	return uint32(r) | uint32(g)<<8 | uint32(b)<<16 | uint32(a)<<24
+}
+
+// Channels unpacks a color into r, g, b and a.
+func Channels(c uint32) (r byte, g byte, b byte, a byte) {
+	return byte(c), byte(c >> 8), byte(c >> 16), byte(c >> 24)
+}
+
+// BlendMode is how a color that gets written is combined with the pixel
+// that is already there.
+type BlendMode uint32
+
+const (
+	// BLEND_ALPHA draws the color over the pixel, this is the default
+	BLEND_ALPHA BlendMode = iota
+	// BLEND_REPLACE ignores the alpha and overwrites the pixel
+	BLEND_REPLACE
+	BLEND_ADD
+	BLEND_MULTIPLY
+	BLEND_XOR
+	BLEND_AMOUNT
+)
+
+var blendModeNames = [BLEND_AMOUNT]string{"alpha", "replace", "add", "multiply", "xor"}
+
+func (m BlendMode) String() string {
+	if m >= BLEND_AMOUNT {
+		return fmt.Sprintf("BlendMode(%d)", uint32(m))
+	}
+	return blendModeNames[m]
+}
+
+func ParseBlendMode(s string) (BlendMode, error) {
+	for i, name := range blendModeNames {
+		if strings.EqualFold(s, name) {
+			return BlendMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown blend mode %q", s)
+}
+
+// blend is Blend for colors with channels of bits bits.
+func (m BlendMode) blend(dst uint64, src uint64, bits int) uint64 {
+	full := uint64(1)<<bits - 1
+	if m == BLEND_REPLACE {
+		return src | full<<(3*bits)
+	}
+	alpha := src >> (3 * bits) & full
+	out := full << (3 * bits)
+	for shift := 0; shift < 3*bits; shift += bits {
+		d, s := dst>>shift&full, src>>shift&full
+		var c uint64
+		switch m {
+		case BLEND_ADD:
+			c = min(d+s, full)
+		case BLEND_MULTIPLY:
+			c = (d*s + full/2) / full
+		case BLEND_XOR:
+			c = d ^ s
+		default:
+			c = s
+		}
+		out |= ((c*alpha + d*(full-alpha) + full/2) / full) << shift
+	}
+	return out
+}
+
+// Blend combines src with the opaque pixel dst. Except for replace, the
+// result of the mode is drawn over dst with the alpha of src.
+func (m BlendMode) Blend(dst uint32, src uint32) uint32 {
+	return uint32(m.blend(uint64(dst), uint64(src), 8))
+}
+
+// Blend64 is Blend for colors with 16 bits per channel.
+func (m BlendMode) Blend64(dst uint64, src uint64) uint64 {
+	return m.blend(dst, src, 16)
+}
@@ -0,0 +1,48 @@
+package types
+
+import "testing"
+
+func TestBlend(t *testing.T) {
+	tests := []struct {
+		name string
+		mode BlendMode
+		dst  uint32
+		src  uint32
+		want uint32
+	}{
+		{"alpha opaque", BLEND_ALPHA, RGBA(0x10, 0x20, 0x30, 0xff), RGBA(1, 2, 3, 0xff), RGBA(1, 2, 3, 0xff)},
+		{"alpha half", BLEND_ALPHA, RGBA(0, 0, 0, 0xff), RGBA(0xff, 0, 0, 0x80), RGBA(0x80, 0, 0, 0xff)},
+		{"alpha transparent", BLEND_ALPHA, RGBA(1, 2, 3, 0xff), RGBA(0xff, 0xff, 0xff, 0), RGBA(1, 2, 3, 0xff)},
+		{"replace ignores alpha", BLEND_REPLACE, RGBA(0x10, 0x20, 0x30, 0xff), RGBA(1, 2, 3, 0), RGBA(1, 2, 3, 0xff)},
+		{"add clips", BLEND_ADD, RGBA(0x80, 0x10, 0xf0, 0xff), RGBA(0x90, 0x10, 0x20, 0xff), RGBA(0xff, 0x20, 0xff, 0xff)},
+		{"add half", BLEND_ADD, RGBA(0x80, 0, 0, 0xff), RGBA(0x80, 0, 0, 0x80), RGBA(0xc0, 0, 0, 0xff)},
+		{"multiply", BLEND_MULTIPLY, RGBA(0xff, 0x80, 0, 0xff), RGBA(0x80, 0x80, 0xff, 0xff), RGBA(0x80, 0x40, 0, 0xff)},
+		{"xor", BLEND_XOR, RGBA(0xf0, 0x0f, 0xff, 0xff), RGBA(0xff, 0xff, 0x0f, 0xff), RGBA(0x0f, 0xf0, 0xf0, 0xff)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.mode.Blend(test.dst, test.src); got != test.want {
+				t.Errorf("Blend(%#08x, %#08x) = %#08x, not %#08x", test.dst, test.src, got, test.want)
+			}
+			if got := Narrow(test.mode.Blend64(Widen(test.dst), Widen(test.src))); got != test.want {
+				t.Errorf("Blend64 rounds to %#08x, not %#08x", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNarrowWiden(t *testing.T) {
+	for v := 0; v < 0x100; v++ {
+		c := RGBA(byte(v), byte(v), byte(v), byte(v))
+		if got := Narrow(Widen(c)); got != c {
+			t.Errorf("%#08x widens and narrows to %#08x", c, got)
+		}
+	}
+	for v := uint64(0); v < 0x10000; v++ {
+		c := RGBA64(uint16(v), 0, 0xffff, 0xffff)
+		wide := Widen(Narrow(c))
+		if diff := int64(wide&0xffff) - int64(v); diff < -0x80 || diff > 0x80 || wide>>16 != c>>16 {
+			t.Fatalf("%#016x narrows and widens to %#016x", c, wide)
+		}
+	}
+}
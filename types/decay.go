@@ -0,0 +1,69 @@
+package types
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// fade moves every channel of c at most step closer to target.
+func fade(c uint32, target uint32, step int) uint32 {
+	var faded uint32
+	for shift := 0; shift < 32; shift += 8 {
+		from := int(c >> shift & 0xff)
+		to := int(target >> shift & 0xff)
+		faded |= uint32(from+min(max(to-from, -step), step)) << shift
+	}
+	return faded
+}
+
+// decay calls reset for every pixel that also exists in background with its
+// current color and the color in background, and stores what it returns.
+// Pixels that changed are given to the server when release is set, on deep
+// grids a pixel also changes when only its deep color is reset.
+func (g *Grid) decay(background *Grid, release bool, reset func(b *buffer, idx int, c uint32, target uint32) uint32) (count int) {
+	b, bg := g.buf.Load(), background.buf.Load()
+	for y := 0; y < min(b.sizeY, bg.sizeY); y++ {
+		for x := 0; x < min(b.sizeX, bg.sizeX); x++ {
+			idx := y*b.sizeX + x
+			c := b.cells[idx]
+			updated := reset(b, idx, c, bg.cells[y*bg.sizeX+x])
+			if updated == c && (b.deep == nil || b.deep[idx] == Widen(c|0xff<<24)) {
+				continue
+			}
+			b.setCell(idx, updated)
+			if release {
+				g.Owners.record(b, idx, nil)
+			}
+			count++
+		}
+	}
+	atomic.AddUint64(&g.ChangedPixels, uint64(count))
+	return
+}
+
+// Fade moves every pixel at most step per channel closer to the same pixel of
+// background. The pixels keep their owners.
+func (g *Grid) Fade(background *Grid, step int) int {
+	return g.decay(background, false, func(_ *buffer, _ int, c uint32, target uint32) uint32 {
+		return fade(c, target, step)
+	})
+}
+
+// Wipe replaces every pixel with the same pixel of background.
+func (g *Grid) Wipe(background *Grid) int {
+	return g.decay(background, true, func(_ *buffer, _ int, _ uint32, target uint32) uint32 {
+		return target
+	})
+}
+
+// ClearStale resets the pixels that no client wrote to for age to the same
+// pixel of background.
+func (g *Grid) ClearStale(background *Grid, age time.Duration) int {
+	oldest := int64(g.Owners.now()) - int64(age/time.Second)
+	return g.decay(background, true, func(b *buffer, idx int, c uint32, target uint32) uint32 {
+		if atomic.LoadUint32(&b.owners[idx]) == 0 || int64(atomic.LoadUint32(&b.stamps[idx])) > oldest {
+			return c
+		}
+		return target
+	})
+}
@@ -0,0 +1,117 @@
+package types
+
+// Deep grids also keep every pixel with 16 bits per channel, so blending many
+// transparent colors gives smooth results. The 8 bit cells are still what
+// everything reads, a deep pixel is only used while it matches its cell, so
+// the server can keep writing just the cells.
+
+// RGBA64 packs a color with 16 bits per channel as 0xAAAABBBBGGGGRRRR.
+func RGBA64(r uint16, g uint16, b uint16, a uint16) uint64 {
+	return uint64(r) | uint64(g)<<16 | uint64(b)<<32 | uint64(a)<<48
+}
+
+// RGB565 turns a color with 5 bits of red, 6 of green and 5 of blue into one
+// with 16 bits per channel.
+func RGB565(c uint16) uint64 {
+	v := uint64(c)
+	return RGBA64(
+		uint16((v>>11)*0xffff/0x1f),
+		uint16((v>>5&0x3f)*0xffff/0x3f),
+		uint16((v&0x1f)*0xffff/0x1f),
+		0xffff,
+	)
+}
+
+// Narrow rounds a color with 16 bits per channel to 8 bits per channel.
+func Narrow(c uint64) uint32 {
+	var narrow uint32
+	for shift := 0; shift < 4; shift++ {
+		v := c >> (16 * shift) & 0xffff
+		narrow |= uint32((v*0xff+0x7fff)/0xffff) << (8 * shift)
+	}
+	return narrow
+}
+
+// Widen turns a color with 8 bits per channel into one with 16 bits per
+// channel.
+func Widen(c uint32) uint64 {
+	var wide uint64
+	for shift := 0; shift < 4; shift++ {
+		wide |= uint64(c>>(8*shift)&0xff) * 0x101 << (16 * shift)
+	}
+	return wide
+}
+
+// EnableDeep makes the grid keep 16 bits per channel from now on.
+func (g *Grid) EnableDeep() {
+	g.resizing.Lock()
+	defer g.resizing.Unlock()
+	old := g.buf.Load()
+	if old.deep != nil {
+		return
+	}
+	b := *old
+	b.deep = make([]uint64, len(b.cells))
+	for i, c := range b.cells {
+		b.deep[i] = Widen(c)
+	}
+	g.buf.Store(&b)
+}
+
+func (g *Grid) Deep() bool {
+	return g.buf.Load().deep != nil
+}
+
+// GetRGBA64 is Get with 16 bits per channel. Pixels of grids that aren't
+// deep, and pixels with a visible layer over them, are widened.
+func (g *Grid) GetRGBA64(x uint16, y uint16) (uint64, error) {
+	b := g.buf.Load()
+	if int(x) >= b.sizeX || int(y) >= b.sizeY {
+		return 0, ErrOutOfBounds
+	}
+	idx := int(y)*b.sizeX + int(x)
+	cell := b.cells[idx] | 0xff<<24
+	c := g.composite(idx, cell)
+	if b.deep == nil || c != cell || Narrow(b.deep[idx]) != cell {
+		return Widen(c), nil
+	}
+	return b.deep[idx], nil
+}
+
+// setCell writes c to the cell at idx, deep buffers get the same color in
+// the deep pixel so they don't keep an old one.
+func (b *buffer) setCell(idx int, c uint32) {
+	b.cells[idx] = c
+	if b.deep != nil {
+		b.deep[idx] = Widen(c | 0xff<<24)
+	}
+}
+
+// blendDeep blends c into the pixel at idx of a deep buffer.
+func (b *buffer) blendDeep(idx int, c uint64, mode BlendMode) {
+	dst := b.deep[idx]
+	if Narrow(dst) != b.cells[idx]|0xff<<24 {
+		// the cell was written without the deep pixel
+		dst = Widen(b.cells[idx])
+	}
+	b.deep[idx] = mode.Blend64(dst, c)
+	b.cells[idx] = Narrow(b.deep[idx])
+}
+
+// SetRGBA64 is Set for a color with 16 bits per channel. Grids that aren't
+// deep and layers round it to 8 bits per channel.
+func (g *Grid) SetRGBA64(xy uint32, c uint64, client *Client) error {
+	b := g.buf.Load()
+	if b.deep == nil || g.writeLayer(client) != nil {
+		return g.Set(xy, Narrow(c), client)
+	}
+	idx, err := g.writeIndex(b, xy, client)
+	if err != nil {
+		return err
+	}
+	b.blendDeep(idx, c, client.BlendMode())
+	g.snap(b, idx)
+	g.Owners.record(b, idx, client)
+	g.inc(client)
+	return nil
+}
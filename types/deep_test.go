@@ -0,0 +1,38 @@
+package types
+
+import (
+	"image"
+	"testing"
+)
+
+// TestDeepOverwrite writes a 16 bit color and then overwrites it in ways that
+// only know 8 bits, the deep pixel has to follow even when the 8 bit color
+// stays the same.
+func TestDeepOverwrite(t *testing.T) {
+	deep := RGBA64(0x8001, 0x8001, 0x8001, 0xffff)
+	gray := RGBA(0x80, 0x80, 0x80, 0xff)
+	tests := []struct {
+		name      string
+		overwrite func(grid *Grid, background *Grid)
+	}{
+		{"SetExact", func(grid *Grid, _ *Grid) { grid.SetExact(0, gray, nil) }},
+		{"Fill", func(grid *Grid, _ *Grid) { grid.Fill(image.Rect(0, 0, 1, 1), gray) }},
+		{"Wipe", func(grid *Grid, background *Grid) { grid.Wipe(background) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			grid := NewGrid(2, 2, 0, 0)
+			grid.EnableDeep()
+			if err := grid.SetRGBA64(0, deep, nil); err != nil {
+				t.Fatal(err)
+			}
+			if c, _ := grid.GetRGBA64(0, 0); c != deep {
+				t.Fatalf("the deep pixel is %#016x, not %#016x", c, deep)
+			}
+			test.overwrite(grid, NewGrid(2, 2, gray, 0))
+			if c, _ := grid.GetRGBA64(0, 0); c != Widen(gray) {
+				t.Errorf("the deep pixel is %#016x, not %#016x", c, Widen(gray))
+			}
+		})
+	}
+}
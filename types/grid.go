@@ -2,40 +2,101 @@ package types
 
 import (
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"math/rand/v2"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 const GRID_AMOUNT = 2
 
+// buffer is everything of a grid that depends on its size, it is replaced
+// as a whole when the grid gets resized.
+type buffer struct {
+	sizeX  int
+	sizeY  int
+	cells  []uint32
+	owners []uint32
+	stamps []uint32
+	// heat is how often clients wrote the pixels recently, as the bits of a
+	// float32 that halves every HEAT_HALF_LIFE
+	heat []uint32
+	// deep is only there for deep grids
+	deep []uint64
+}
+
+func newBuffer(sizeX int, sizeY int) *buffer {
+	return &buffer{
+		sizeX:  sizeX,
+		sizeY:  sizeY,
+		cells:  make([]uint32, sizeX*sizeY),
+		owners: make([]uint32, sizeX*sizeY),
+		stamps: make([]uint32, sizeX*sizeY),
+		heat:   make([]uint32, sizeX*sizeY),
+	}
+}
+
 type Grid struct {
-	SizeX         int
-	SizeY         int
-	length        int
-	Cells         []uint32
+	buf           atomic.Pointer[buffer]
 	Modified      time.Time
 	Index         byte
 	ChangedPixels uint64
+	// Rejected counts the writes that were rejected because they were in a
+	// protected region.
+	Rejected   uint64
+	Protected  *Regions
+	Zones      *Zones
+	Owners     *Owners
+	Palette    *Palette
+	Overlay    *Overlay
+	frozen     uint32
+	generation uint32
+	resizing   *sync.Mutex
+	layers     atomic.Pointer[[]*Layer]
+	// target is the layer clients write to, nil are the cells
+	target        atomic.Pointer[Layer]
+	layersChanged atomic.Uint64
 }
 
-func (g *Grid) inc() {
+var (
+	ErrOutOfBounds = errors.New("out of bounds")
+	ErrFrozen      = errors.New("canvas is frozen")
+	ErrProtected   = errors.New("pixel is protected")
+	ErrZone        = errors.New("pixel is in the zone of another team")
+	ErrRateLimited = errors.New("too many pixels this second")
+)
+
+func (g *Grid) inc(client *Client) {
 	atomic.AddUint64(&g.ChangedPixels, 1)
+	if client != nil {
+		client.Pixels[g.Index].Add(1)
+	}
 }
 
-func NewGrid(sizeX uint16, sizeY uint16, defaultValue uint32, canvasId byte) Grid {
-	grid := Grid{
-		SizeX:    int(sizeX),
-		SizeY:    int(sizeY),
-		length:   int(sizeX) * int(sizeY),
-		Cells:    make([]uint32, (uint32(sizeX) * uint32(sizeY))),
-		Modified: time.Now(),
-		Index:    canvasId,
+func newGrid(sizeX uint16, sizeY uint16, canvasId byte) *Grid {
+	grid := &Grid{
+		Modified:  time.Now(),
+		Index:     canvasId,
+		Protected: NewRegions(),
+		Zones:     NewZones(),
+		Owners:    NewOwners(canvasId),
+		Palette:   NewPalette(),
+		Overlay:   NewOverlay(),
+		resizing:  &sync.Mutex{},
 	}
-	for i := range grid.Cells {
-		grid.Cells[i] = defaultValue
+	grid.buf.Store(newBuffer(int(sizeX), int(sizeY)))
+	grid.layers.Store(&[]*Layer{})
+	return grid
+}
+
+func NewGrid(sizeX uint16, sizeY uint16, defaultValue uint32, canvasId byte) *Grid {
+	grid := newGrid(sizeX, sizeY, canvasId)
+	cells := grid.buf.Load().cells
+	for i := range cells {
+		cells[i] = defaultValue
 	}
 	return grid
 }
@@ -44,69 +105,216 @@ func randomColor() uint32 {
 	return rand.Uint32() | (0xff << 24)
 }
 
-func NewGridRandom(sizeX uint16, sizeY uint16, canvasId byte) Grid {
-	grid := Grid{
-		SizeX:    int(sizeX),
-		SizeY:    int(sizeY),
-		length:   int(sizeX) * int(sizeY),
-		Cells:    make([]uint32, (uint32(sizeX) * uint32(sizeY))),
-		Modified: time.Now(),
-		Index:    canvasId,
-	}
-	for i := range grid.Cells {
-		grid.Cells[i] = randomColor()
-	}
+func NewGridRandom(sizeX uint16, sizeY uint16, canvasId byte) *Grid {
+	grid := newGrid(sizeX, sizeY, canvasId)
+	grid.Randomize()
 	return grid
 }
 
+// Size returns the current width and height of the grid.
+func (g *Grid) Size() (int, int) {
+	b := g.buf.Load()
+	return b.sizeX, b.sizeY
+}
+
+// Generation is increased every time the grid is resized.
+func (g *Grid) Generation() uint32 {
+	return atomic.LoadUint32(&g.generation)
+}
+
+// Get returns the pixel at x, y with the layers drawn over it.
 func (g *Grid) Get(x uint16, y uint16) (uint32, error) {
-	idx := int(y)*int(g.SizeX) + int(x)
-	if idx >= len(g.Cells) {
-		return 0, errors.New("Out of bounds")
+	b := g.buf.Load()
+	if int(x) >= b.sizeX || int(y) >= b.sizeY {
+		return 0, ErrOutOfBounds
 	}
-	return g.Cells[idx] | (0xff << 24), nil
+	idx := int(y)*b.sizeX + int(x)
+	return g.composite(idx, b.cells[idx]|0xff<<24), nil
 }
 
-func blend(src uint32, dst uint32) uint32 {
-	t := dst >> 24
-	s := 255 - t
-	return ((0xff << 24) |
-		(((((src>>16)&0xff)*s +
-			((dst>>16)&0xff)*t) << 8) & 0xffff0000) |
-		((((src>>8)&0xff)*s +
-			((dst>>8)&0xff)*t) & 0xffffff00) |
-		((((src)&0xff)*s +
-			((dst)&0xff)*t) & 0xffffffff))
+// Freeze stops clients from writing to the grid until it is unfrozen.
+func (g *Grid) Freeze(frozen bool) {
+	if frozen {
+		atomic.StoreUint32(&g.frozen, 1)
+	} else {
+		atomic.StoreUint32(&g.frozen, 0)
+	}
+}
 
+func (g *Grid) Frozen() bool {
+	return atomic.LoadUint32(&g.frozen) == 1
 }
 
-func (g *Grid) Set(xy uint32, c uint32) error {
-	idx := int(xy>>16)*int(g.SizeX) + int(xy&0xffff)
-	if idx >= g.length {
-		return errors.New("out of bounds")
+// writeIndex returns the index of the cell at xy in b if client is allowed to
+// write to it. A nil client is the server itself, it can always write.
+func (g *Grid) writeIndex(b *buffer, xy uint32, client *Client) (int, error) {
+	x, y := int(xy&0xffff), int(xy>>16)
+	if x >= b.sizeX || y >= b.sizeY {
+		return 0, ErrOutOfBounds
 	}
-	g.Cells[idx] = blend(g.Cells[idx], c)
-	g.inc()
-	return nil
+	if client != nil {
+		if g.Frozen() {
+			return 0, ErrFrozen
+		}
+		if g.Protected.Contains(x, y) {
+			atomic.AddUint64(&g.Rejected, 1)
+			return 0, ErrProtected
+		}
+		if !g.Zones.Allowed(x, y, client.Team()) {
+			return 0, ErrZone
+		}
+		if !client.allow(1) {
+			return 0, ErrRateLimited
+		}
+	}
+	return y*b.sizeX + x, nil
 }
 
-func (g *Grid) SetExact(xy uint32, c uint32) error {
-	idx := int(xy>>16)*int(g.SizeX) + int(xy&0xffff)
+// snap moves the pixel at idx to the nearest color of the palette.
+func (g *Grid) snap(b *buffer, idx int) {
+	if len(g.Palette.Colors()) == 0 {
+		return
+	}
+	b.cells[idx] = g.Palette.Nearest(b.cells[idx])
+	if b.deep != nil {
+		b.deep[idx] = Widen(b.cells[idx])
+	}
+}
 
-	if idx >= g.length {
-		return errors.New("out of bounds")
+// SetIndex sets the pixel at xy to the color at index i of the palette.
+func (g *Grid) SetIndex(xy uint32, i int, client *Client) error {
+	c, err := g.Palette.Color(i)
+	if err != nil {
+		return err
 	}
-	g.Cells[idx] = c
-	g.inc()
+	return g.SetExact(xy, c, client)
+}
+
+// Set blends c into the pixel at xy with the blend mode of client, the
+// server itself draws with alpha blending.
+func (g *Grid) Set(xy uint32, c uint32, client *Client) error {
+	b := g.buf.Load()
+	idx, err := g.writeIndex(b, xy, client)
+	if err != nil {
+		return err
+	}
+	if layer := g.writeLayer(client); layer != nil {
+		layer.set(idx, c, client.BlendMode(), g.Palette)
+	} else if b.deep != nil {
+		b.blendDeep(idx, Widen(c), client.BlendMode())
+		g.snap(b, idx)
+	} else {
+		b.cells[idx] = client.BlendMode().Blend(b.cells[idx], c)
+		g.snap(b, idx)
+	}
+	g.Owners.record(b, idx, client)
+	g.inc(client)
+	return nil
+}
+
+// SetExact replaces the pixel at xy with c, whatever the blend mode of client
+// is.
+func (g *Grid) SetExact(xy uint32, c uint32, client *Client) error {
+	b := g.buf.Load()
+	idx, err := g.writeIndex(b, xy, client)
+	if err != nil {
+		return err
+	}
+	if layer := g.writeLayer(client); layer != nil {
+		layer.set(idx, c|0xff<<24, BLEND_REPLACE, g.Palette)
+	} else {
+		b.setCell(idx, c|0xff<<24)
+		g.snap(b, idx)
+	}
+	g.Owners.record(b, idx, client)
+	g.inc(client)
 	return nil
 }
 
+// Fill sets every pixel of r that is inside the grid to c. It is meant for
+// moderation so it also works on frozen grids and protected regions.
+func (g *Grid) Fill(r image.Rectangle, c uint32) (count int) {
+	b := g.buf.Load()
+	r = r.Intersect(image.Rect(0, 0, b.sizeX, b.sizeY))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			b.setCell(y*b.sizeX+x, c)
+			g.Owners.record(b, y*b.sizeX+x, nil)
+			count++
+		}
+	}
+	atomic.AddUint64(&g.ChangedPixels, uint64(count))
+	return
+}
+
+// ParseAnchor turns a compass direction like "nw", "s" or "c" for the center
+// into an anchor for Resize.
+func ParseAnchor(s string) (image.Point, error) {
+	anchors := map[string]image.Point{
+		"nw": {0, 0}, "n": {1, 0}, "ne": {2, 0},
+		"w": {0, 1}, "c": {1, 1}, "e": {2, 1},
+		"sw": {0, 2}, "s": {1, 2}, "se": {2, 2},
+	}
+	anchor, found := anchors[s]
+	if !found {
+		return anchor, fmt.Errorf("unknown anchor %q", s)
+	}
+	return anchor, nil
+}
+
+// Resize changes the size of the grid and keeps its content. The anchor is
+// where the old content ends up in halves of the difference in size, so
+// (0, 0) keeps the top left corner in place and (1, 1) the center. New pixels
+// get the background color. Writes that are still busy with the old buffer
+// stay in range, but they can get lost.
+func (g *Grid) Resize(sizeX uint16, sizeY uint16, anchor image.Point, background uint32) {
+	g.resizing.Lock()
+	defer g.resizing.Unlock()
+
+	old := g.buf.Load()
+	b := newBuffer(int(sizeX), int(sizeY))
+	if old.deep != nil {
+		b.deep = make([]uint64, len(b.cells))
+	}
+	for i := range b.cells {
+		b.setCell(i, background)
+	}
+	dx := (b.sizeX - old.sizeX) * anchor.X / 2
+	dy := (b.sizeY - old.sizeY) * anchor.Y / 2
+	for _, layer := range *g.layers.Load() {
+		layer.resize(b.sizeX, b.sizeY, dx, dy)
+	}
+	for y := 0; y < old.sizeY; y++ {
+		for x := 0; x < old.sizeX; x++ {
+			from := y*old.sizeX + x
+			nx, ny := x+dx, y+dy
+			if nx < 0 || ny < 0 || nx >= b.sizeX || ny >= b.sizeY {
+				// the pixel is cut off, so nobody owns it anymore
+				g.Owners.release(atomic.LoadUint32(&old.owners[from]))
+				continue
+			}
+			to := ny*b.sizeX + nx
+			b.cells[to] = old.cells[from]
+			b.owners[to] = atomic.LoadUint32(&old.owners[from])
+			b.stamps[to] = atomic.LoadUint32(&old.stamps[from])
+			b.heat[to] = atomic.LoadUint32(&old.heat[from])
+			if b.deep != nil {
+				b.deep[to] = old.deep[from]
+			}
+		}
+	}
+	g.buf.Store(b)
+	atomic.AddUint32(&g.generation, 1)
+	g.Modified = time.Now()
+}
+
 func (g *Grid) ColorModel() color.Model {
 	return color.RGBAModel
 }
 
 func (g *Grid) Bounds() image.Rectangle {
-	return image.Rect(0, 0, int(g.SizeX), int(g.SizeY))
+	sizeX, sizeY := g.Size()
+	return image.Rect(0, 0, sizeX, sizeY)
 }
 
 func (g *Grid) At(x, y int) color.Color {
@@ -0,0 +1,277 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"regexp"
+	"sync/atomic"
+)
+
+// Layers are transparent images on top of the cells of a grid. They are
+// drawn over the cells in order, with their opacity, and everything that
+// reads the grid sees the result. Clients write to the write layer of the
+// grid, the server always writes to the cells.
+
+var (
+	ErrLayerExists  = errors.New("there already is a layer with that name")
+	ErrUnknownLayer = errors.New("unknown layer")
+)
+
+var layerName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// LayerInfo describes a layer, the opacity is multiplied with the alpha of
+// its pixels.
+type LayerInfo struct {
+	Name    string `json:"name"`
+	Opacity uint8  `json:"opacity"`
+	Visible bool   `json:"visible"`
+}
+
+// layerBuffer are the pixels of a layer, which unlike cells have an alpha.
+type layerBuffer struct {
+	sizeX int
+	sizeY int
+	cells []uint32
+}
+
+type Layer struct {
+	name    string
+	buf     atomic.Pointer[layerBuffer]
+	opacity atomic.Uint32
+	visible atomic.Bool
+	// changed is increased when the layer changes without its pixels being
+	// written, so the streams can notice
+	changed *atomic.Uint64
+}
+
+func (l *Layer) Info() LayerInfo {
+	return LayerInfo{l.name, uint8(l.opacity.Load()), l.visible.Load()}
+}
+
+func (l *Layer) SetOpacity(opacity uint8) {
+	l.opacity.Store(uint32(opacity))
+	l.changed.Add(1)
+}
+
+func (l *Layer) SetVisible(visible bool) {
+	l.visible.Store(visible)
+	l.changed.Add(1)
+}
+
+// Fill sets every pixel of r that is inside the layer to c, a transparent
+// color clears them.
+func (l *Layer) Fill(r image.Rectangle, c uint32) (count int) {
+	b := l.buf.Load()
+	r = r.Intersect(image.Rect(0, 0, b.sizeX, b.sizeY))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			b.cells[y*b.sizeX+x] = c
+			count++
+		}
+	}
+	l.changed.Add(1)
+	return
+}
+
+// Draw copies img onto the layer with its top left corner at offset, the
+// alpha of img is kept.
+func (l *Layer) Draw(img image.Image, offset image.Point) (count int) {
+	b := l.buf.Load()
+	bounds := img.Bounds()
+	area := bounds.Add(offset.Sub(bounds.Min)).Intersect(image.Rect(0, 0, b.sizeX, b.sizeY))
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			r, g, bl, a := img.At(x-offset.X+bounds.Min.X, y-offset.Y+bounds.Min.Y).RGBA()
+			if a != 0 {
+				// RGBA is premultiplied
+				r, g, bl = r*0xffff/a, g*0xffff/a, bl*0xffff/a
+			}
+			b.cells[y*b.sizeX+x] = RGBA(byte(r>>8), byte(g>>8), byte(bl>>8), byte(a>>8))
+			count++
+		}
+	}
+	l.changed.Add(1)
+	return
+}
+
+// Image returns a copy of the pixels of the layer.
+func (l *Layer) Image() *image.NRGBA {
+	b := l.buf.Load()
+	img := image.NewNRGBA(image.Rect(0, 0, b.sizeX, b.sizeY))
+	for i, c := range b.cells {
+		img.Pix[4*i], img.Pix[4*i+1], img.Pix[4*i+2], img.Pix[4*i+3] = Channels(c)
+	}
+	return img
+}
+
+// blendLayer draws src over the pixel dst of a layer, which can be
+// transparent. Replace keeps the alpha of src, so it can also erase.
+func blendLayer(dst uint32, src uint32, mode BlendMode) uint32 {
+	if mode == BLEND_REPLACE {
+		return src
+	}
+	sa, da := src>>24, dst>>24
+	if sa == 0 {
+		return dst
+	}
+	// the color of the mode, as if both were opaque
+	mixed := mode.Blend(dst|0xff<<24, src|0xff<<24)
+	// the alpha of the result, times 0xff
+	alpha := sa*0xff + da*(0xff-sa)
+	out := (alpha + 0x7f) / 0xff << 24
+	for shift := 0; shift < 24; shift += 8 {
+		s, d := mixed>>shift&0xff, dst>>shift&0xff
+		out |= (s*sa*0xff + d*da*(0xff-sa) + alpha/2) / alpha << shift
+	}
+	return out
+}
+
+// set blends c into the pixel at idx, writes that happen while the grid is
+// resized are dropped.
+func (l *Layer) set(idx int, c uint32, mode BlendMode, palette *Palette) {
+	b := l.buf.Load()
+	if idx >= len(b.cells) {
+		return
+	}
+	c = blendLayer(b.cells[idx], c, mode)
+	if len(palette.Colors()) > 0 && c>>24 != 0 {
+		c = palette.Nearest(c)&0xffffff | c&(0xff<<24)
+	}
+	b.cells[idx] = c
+}
+
+// resize moves the pixels of the layer like Resize does with the cells, new
+// pixels are transparent.
+func (l *Layer) resize(sizeX int, sizeY int, dx int, dy int) {
+	old := l.buf.Load()
+	b := &layerBuffer{sizeX, sizeY, make([]uint32, sizeX*sizeY)}
+	for y := 0; y < old.sizeY; y++ {
+		for x := 0; x < old.sizeX; x++ {
+			nx, ny := x+dx, y+dy
+			if nx >= 0 && ny >= 0 && nx < sizeX && ny < sizeY {
+				b.cells[ny*sizeX+nx] = old.cells[y*old.sizeX+x]
+			}
+		}
+	}
+	l.buf.Store(b)
+}
+
+// composite draws the visible layers over the opaque pixel c at idx.
+func (g *Grid) composite(idx int, c uint32) uint32 {
+	for _, layer := range *g.layers.Load() {
+		if !layer.visible.Load() {
+			continue
+		}
+		cells := layer.buf.Load().cells
+		if idx >= len(cells) {
+			continue
+		}
+		p := cells[idx]
+		if alpha := (p>>24*layer.opacity.Load() + 0x7f) / 0xff; alpha != 0 {
+			c = BLEND_ALPHA.Blend(c, p&0xffffff|alpha<<24)
+		}
+	}
+	return c
+}
+
+// writeLayer returns the layer that client writes to, nil means the cells.
+// The server always writes to the cells.
+func (g *Grid) writeLayer(client *Client) *Layer {
+	if client == nil {
+		return nil
+	}
+	return g.target.Load()
+}
+
+// AddLayer puts a new transparent layer on top of the others.
+func (g *Grid) AddLayer(info LayerInfo) (*Layer, error) {
+	if !layerName.MatchString(info.Name) {
+		return nil, fmt.Errorf("invalid layer name %q, it can only have a-z, 0-9, _ and -", info.Name)
+	}
+	g.resizing.Lock()
+	defer g.resizing.Unlock()
+	old := *g.layers.Load()
+	for _, layer := range old {
+		if layer.name == info.Name {
+			return nil, ErrLayerExists
+		}
+	}
+	sizeX, sizeY := g.Size()
+	layer := &Layer{name: info.Name, changed: &g.layersChanged}
+	layer.buf.Store(&layerBuffer{sizeX, sizeY, make([]uint32, sizeX*sizeY)})
+	layer.opacity.Store(uint32(info.Opacity))
+	layer.visible.Store(info.Visible)
+	layers := append(old[:len(old):len(old)], layer)
+	g.layers.Store(&layers)
+	g.layersChanged.Add(1)
+	return layer, nil
+}
+
+// Layer returns the layer called name.
+func (g *Grid) Layer(name string) (*Layer, error) {
+	for _, layer := range *g.layers.Load() {
+		if layer.name == name {
+			return layer, nil
+		}
+	}
+	return nil, ErrUnknownLayer
+}
+
+// Layers describes the layers from the bottom to the top.
+func (g *Grid) Layers() []LayerInfo {
+	layers := *g.layers.Load()
+	infos := make([]LayerInfo, len(layers))
+	for i, layer := range layers {
+		infos[i] = layer.Info()
+	}
+	return infos
+}
+
+// RemoveLayer removes the layer called name, when clients were writing to it
+// they write to the cells again.
+func (g *Grid) RemoveLayer(name string) error {
+	g.resizing.Lock()
+	defer g.resizing.Unlock()
+	old := *g.layers.Load()
+	for i, layer := range old {
+		if layer.name != name {
+			continue
+		}
+		layers := append(old[:i:i], old[i+1:]...)
+		g.layers.Store(&layers)
+		g.target.CompareAndSwap(layer, nil)
+		g.layersChanged.Add(1)
+		return nil
+	}
+	return ErrUnknownLayer
+}
+
+// SetWriteLayer makes clients write to the layer called name, an empty name
+// are the cells.
+func (g *Grid) SetWriteLayer(name string) error {
+	if name == "" {
+		g.target.Store(nil)
+		return nil
+	}
+	layer, err := g.Layer(name)
+	if err != nil {
+		return err
+	}
+	g.target.Store(layer)
+	return nil
+}
+
+// WriteLayer is the name of the layer clients write to, empty for the cells.
+func (g *Grid) WriteLayer() string {
+	if layer := g.target.Load(); layer != nil {
+		return layer.name
+	}
+	return ""
+}
+
+// LayersChanged grows whenever a layer changes in another way than clients
+// writing to it.
+func (g *Grid) LayersChanged() uint64 {
+	return g.layersChanged.Load()
+}
@@ -0,0 +1,27 @@
+package types
+
+import "testing"
+
+func TestBlendLayer(t *testing.T) {
+	tests := []struct {
+		name string
+		mode BlendMode
+		dst  uint32
+		src  uint32
+		want uint32
+	}{
+		{"replace erases", BLEND_REPLACE, RGBA(1, 2, 3, 0xff), 0, 0},
+		{"transparent keeps the pixel", BLEND_ALPHA, RGBA(1, 2, 3, 0x40), RGBA(0xff, 0xff, 0xff, 0), RGBA(1, 2, 3, 0x40)},
+		{"onto transparent", BLEND_ALPHA, 0, RGBA(0xff, 0, 0, 0x80), RGBA(0xff, 0, 0, 0x80)},
+		{"opaque covers", BLEND_ALPHA, RGBA(0, 0, 0xff, 0x80), RGBA(0xff, 0, 0, 0xff), RGBA(0xff, 0, 0, 0xff)},
+		{"half over half", BLEND_ALPHA, RGBA(0, 0, 0xff, 0x80), RGBA(0xff, 0, 0, 0x80), RGBA(0xaa, 0, 0x55, 0xc0)},
+		{"add", BLEND_ADD, RGBA(0x80, 0, 0, 0xff), RGBA(0x80, 0, 0, 0xff), RGBA(0xff, 0, 0, 0xff)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := blendLayer(test.dst, test.src, test.mode); got != test.want {
+				t.Errorf("blendLayer(%#08x, %#08x) = %#08x, not %#08x", test.dst, test.src, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Score is a single entry of the leaderboard of a canvas. Clients that
+// authenticated as a team are counted together with the rest of their team.
+type Score struct {
+	Name string `json:"n"`
+	Team bool   `json:"t"`
+	// Owned is the amount of pixels that currently show their last write
+	Owned int64 `json:"o"`
+	// Written is the amount of pixels they wrote in total
+	Written uint64 `json:"w"`
+}
+
+// Clients returns the clients that own pixels, including the ones that
+// disconnected since.
+func (o *Owners) Clients() []*Client {
+	clients := []*Client{}
+	o.clients.Range(func(_, client any) bool {
+		clients = append(clients, client.(*Client))
+		return true
+	})
+	return clients
+}
+
+// Leaderboard ranks the connected clients and the clients that still own
+// pixels on grid by the amount of pixels they own. At most limit scores are
+// returned.
+func (r *Registry) Leaderboard(grid *Grid, limit int) []Score {
+	seen := make(map[uint64]bool)
+	scores := make(map[string]*Score)
+	add := func(client *Client) {
+		if seen[client.Id] {
+			return
+		}
+		seen[client.Id] = true
+		owned := client.Owned[grid.Index].Load()
+		written := client.Pixels[grid.Index].Load()
+		if owned <= 0 && written == 0 {
+			return
+		}
+		team := client.Team()
+		name := fmt.Sprintf("#%d", client.Id)
+		if team != "" {
+			name = team
+		}
+		score, found := scores[name]
+		if !found {
+			score = &Score{Name: name, Team: team != ""}
+			scores[name] = score
+		}
+		score.Owned += max(owned, 0)
+		score.Written += written
+	}
+	for _, client := range r.Clients() {
+		add(client)
+	}
+	for _, client := range grid.Owners.Clients() {
+		add(client)
+	}
+
+	board := make([]Score, 0, len(scores))
+	for _, score := range scores {
+		board = append(board, *score)
+	}
+	sort.Slice(board, func(i, j int) bool {
+		if board[i].Owned != board[j].Owned {
+			return board[i].Owned > board[j].Owned
+		}
+		return board[i].Written > board[j].Written
+	})
+	if len(board) > limit {
+		board = board[:limit]
+	}
+	return board
+}
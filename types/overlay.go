@@ -0,0 +1,97 @@
+package types
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"sync/atomic"
+)
+
+// Overlay is a transparent image that is shown over a grid in the streams.
+// It isn't part of the grid, so clients can't read or overwrite it.
+type Overlay struct {
+	img     atomic.Pointer[image.NRGBA]
+	version atomic.Uint64
+}
+
+func NewOverlay() *Overlay {
+	return &Overlay{}
+}
+
+// Store replaces the image of the overlay, nil removes it. Images with the
+// same content as the current one are ignored.
+func (o *Overlay) Store(img *image.NRGBA) {
+	old := o.img.Load()
+	if old == img || old != nil && img != nil && old.Rect == img.Rect && bytes.Equal(old.Pix, img.Pix) {
+		return
+	}
+	o.img.Store(img)
+	o.version.Add(1)
+}
+
+func (o *Overlay) Load() *image.NRGBA {
+	return o.img.Load()
+}
+
+// Version changes whenever the image of the overlay changes.
+func (o *Overlay) Version() uint64 {
+	return o.version.Load()
+}
+
+// composite is a grid with its overlay drawn over it.
+type composite struct {
+	*Grid
+	overlay *image.NRGBA
+}
+
+func (c composite) At(x, y int) color.Color {
+	base, _ := c.Get(uint16(x), uint16(y))
+	if o := c.overlay.NRGBAAt(x, y); o.A != 0 {
+		base = BLEND_ALPHA.Blend(base, RGBA(o.R, o.G, o.B, o.A))
+	}
+	r, g, b, a := Channels(base)
+	return color.RGBA{R: r, G: g, B: b, A: a}
+}
+
+// Composited is what the streams show of the grid, with its overlay.
+func (g *Grid) Composited() image.Image {
+	overlay := g.Overlay.Load()
+	if overlay == nil {
+		return g
+	}
+	return composite{g, overlay}
+}
+
+// Frame is what Composited shows, scaled down to sizeX by sizeY. It reads
+// the cells directly, every pixel of the frame is the average of the pixels
+// it covers.
+func (g *Grid) Frame(sizeX int, sizeY int) *image.RGBA {
+	b := g.buf.Load()
+	overlay := g.Overlay.Load()
+	sizeX, sizeY = min(max(sizeX, 1), b.sizeX), min(max(sizeY, 1), b.sizeY)
+	frame := image.NewRGBA(image.Rect(0, 0, sizeX, sizeY))
+	for y := 0; y < sizeY; y++ {
+		y0 := y * b.sizeY / sizeY
+		y1 := max((y+1)*b.sizeY/sizeY, y0+1)
+		for x := 0; x < sizeX; x++ {
+			x0 := x * b.sizeX / sizeX
+			x1 := max((x+1)*b.sizeX/sizeX, x0+1)
+			var r, gr, bl, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					idx := sy*b.sizeX + sx
+					c := g.composite(idx, b.cells[idx]|0xff<<24)
+					if overlay != nil {
+						if o := overlay.NRGBAAt(sx, sy); o.A != 0 {
+							c = BLEND_ALPHA.Blend(c, RGBA(o.R, o.G, o.B, o.A))
+						}
+					}
+					r, gr, bl, n = r+c&0xff, gr+c>>8&0xff, bl+c>>16&0xff, n+1
+				}
+			}
+			i := frame.PixOffset(x, y)
+			frame.Pix[i], frame.Pix[i+1], frame.Pix[i+2], frame.Pix[i+3] = byte(r/n), byte(gr/n), byte(bl/n), 0xff
+		}
+	}
+	return frame
+}
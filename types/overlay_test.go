@@ -0,0 +1,28 @@
+package types
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	grid := NewGrid(4, 2, 0, 0)
+	for _, xy := range []uint32{0, 1, 1 << 16, 1<<16 | 1} {
+		grid.Set(xy, RGBA(0xff, 0, 0, 0xff), nil)
+	}
+	overlay := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	overlay.SetNRGBA(3, 1, color.NRGBA{0, 0, 0xff, 0xff})
+	grid.Overlay.Store(overlay)
+
+	frame := grid.Frame(2, 1)
+	if frame.Bounds() != image.Rect(0, 0, 2, 1) {
+		t.Fatalf("the frame is %v", frame.Bounds())
+	}
+	want := []color.RGBA{{0xff, 0, 0, 0xff}, {0, 0, 0x3f, 0xff}}
+	for x, c := range want {
+		if got := frame.RGBAAt(x, 0); got != c {
+			t.Errorf("pixel %d is %v, not %v", x, got, c)
+		}
+	}
+}
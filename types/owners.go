@@ -0,0 +1,216 @@
+package types
+
+import (
+	"hash/fnv"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Owners remembers for every pixel of a grid which client wrote it last and
+// when. It keeps the clients that still own pixels around after they
+// disconnect, so they can still be looked up.
+type Owners struct {
+	epoch   time.Time
+	canvas  byte
+	clients sync.Map
+	lock    *sync.Mutex
+}
+
+func NewOwners(canvasId byte) *Owners {
+	return &Owners{
+		epoch:  time.Now(),
+		canvas: canvasId,
+		lock:   &sync.Mutex{},
+	}
+}
+
+func (o *Owners) now() uint32 {
+	return uint32(time.Since(o.epoch) / time.Second)
+}
+
+func (o *Owners) client(id uint32) *Client {
+	if client, found := o.clients.Load(id); found {
+		return client.(*Client)
+	}
+	return nil
+}
+
+// record marks client as the last writer of the pixel at idx of b, a nil
+// client is the server itself. The owner is swapped atomically, so when
+// clients race for a pixel every change of owner is counted exactly once.
+func (o *Owners) record(b *buffer, idx int, client *Client) {
+	var id uint32
+	if client != nil {
+		id = uint32(client.Id)
+		// count the pixel before it is owned, so whoever takes it away can
+		// always find the client
+		o.acquire(client)
+	}
+	old := atomic.SwapUint32(&b.owners[idx], id)
+	now := o.now()
+	last := atomic.SwapUint32(&b.stamps[idx], now)
+	warm(b, idx, client != nil, now-min(last, now))
+	o.release(old)
+}
+
+// HEAT_HALF_LIFE is how long it takes for a write to count half as much in
+// the heatmap.
+const HEAT_HALF_LIFE = time.Minute
+
+// cooled is what is left of heat after seconds.
+func cooled(heat float32, seconds uint32) float32 {
+	return heat * float32(math.Exp2(-float64(seconds)/HEAT_HALF_LIFE.Seconds()))
+}
+
+// warm adds a write to the heat of the pixel at idx, which was last written
+// seconds ago. Writes of the server reset it.
+func warm(b *buffer, idx int, byClient bool, seconds uint32) {
+	for {
+		old := atomic.LoadUint32(&b.heat[idx])
+		var heat float32
+		if byClient {
+			heat = cooled(math.Float32frombits(old), seconds) + 1
+		}
+		if atomic.CompareAndSwapUint32(&b.heat[idx], old, math.Float32bits(heat)) {
+			return
+		}
+	}
+}
+
+// acquire gives a pixel to client. Clients are only remembered while they
+// own pixels, going from and to no pixels happens under the lock so the
+// clients map agrees with the counters.
+func (o *Owners) acquire(client *Client) {
+	owned := &client.Owned[o.canvas]
+	for {
+		n := owned.Load()
+		if n <= 0 {
+			break
+		}
+		if owned.CompareAndSwap(n, n+1) {
+			return
+		}
+	}
+	o.lock.Lock()
+	owned.Add(1)
+	o.clients.Store(uint32(client.Id), client)
+	o.lock.Unlock()
+}
+
+// release takes a pixel away from the client with id.
+func (o *Owners) release(id uint32) {
+	previous := o.client(id)
+	if previous == nil {
+		return
+	}
+	owned := &previous.Owned[o.canvas]
+	for {
+		n := owned.Load()
+		if n <= 1 {
+			break
+		}
+		if owned.CompareAndSwap(n, n-1) {
+			return
+		}
+	}
+	o.lock.Lock()
+	if owned.Add(-1) <= 0 {
+		o.clients.Delete(id)
+	}
+	o.lock.Unlock()
+}
+
+// Owner is the summary of what a client owns in a part of the grid, a nil
+// Client means the pixels were last written by the server.
+type Owner struct {
+	Client    *ClientStats `json:"client"`
+	Pixels    int          `json:"pixels"`
+	LastWrite time.Time    `json:"last_write"`
+}
+
+// Lookup returns who owns the pixels in r and when they last wrote there.
+func (g *Grid) Lookup(r image.Rectangle) []Owner {
+	o, b := g.Owners, g.buf.Load()
+	r = r.Intersect(image.Rect(0, 0, b.sizeX, b.sizeY))
+	owners := []Owner{}
+	indices := make(map[uint32]int)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			idx := y*b.sizeX + x
+			id := atomic.LoadUint32(&b.owners[idx])
+			stamp := o.epoch.Add(time.Duration(atomic.LoadUint32(&b.stamps[idx])) * time.Second)
+			i, found := indices[id]
+			if !found {
+				owner := Owner{}
+				if client := o.client(id); client != nil && id != 0 {
+					stats := client.Stats()
+					owner.Client = &stats
+				}
+				i = len(owners)
+				indices[id] = i
+				owners = append(owners, owner)
+			}
+			owners[i].Pixels++
+			if stamp.After(owners[i].LastWrite) {
+				owners[i].LastWrite = stamp
+			}
+		}
+	}
+	return owners
+}
+
+func ownerColor(id uint32) color.RGBA {
+	h := fnv.New32a()
+	h.Write([]byte{byte(id), byte(id >> 8), byte(id >> 16), byte(id >> 24)})
+	c := h.Sum32()
+	return color.RGBA{R: byte(c) | 0x40, G: byte(c>>8) | 0x40, B: byte(c>>16) | 0x40, A: 0xff}
+}
+
+// heatColor goes from black through red and yellow to white.
+func heatColor(heat float64) color.RGBA {
+	v := int(heat * 3 * 255)
+	channel := func(offset int) byte {
+		return byte(min(max(v-offset, 0), 255))
+	}
+	return color.RGBA{R: channel(0), G: channel(255), B: channel(510), A: 0xff}
+}
+
+// Heatmap draws the pixels written in the last window. By default brighter
+// pixels were written more often, where every write counts half as much
+// after HEAT_HALF_LIFE. The brightness is logarithmic and relative to the
+// most written pixel, so pixels that were written once still show. With
+// byOwner every owner gets its own color instead.
+func (g *Grid) Heatmap(window time.Duration, byOwner bool) *image.RGBA {
+	b := g.buf.Load()
+	img := image.NewRGBA(image.Rect(0, 0, b.sizeX, b.sizeY))
+	now := g.Owners.now()
+	seconds := uint32(window / time.Second)
+	heat := make([]float64, len(b.owners))
+	hottest := 0.0
+	for idx := range b.owners {
+		id := atomic.LoadUint32(&b.owners[idx])
+		age := now - min(atomic.LoadUint32(&b.stamps[idx]), now)
+		if id == 0 || age >= seconds {
+			continue
+		}
+		if byOwner {
+			img.SetRGBA(idx%b.sizeX, idx/b.sizeX, ownerColor(id))
+			continue
+		}
+		heat[idx] = math.Log1p(float64(cooled(math.Float32frombits(atomic.LoadUint32(&b.heat[idx])), age)))
+		hottest = max(hottest, heat[idx])
+	}
+	for idx, h := range heat {
+		if h > 0 {
+			img.SetRGBA(idx%b.sizeX, idx/b.sizeX, heatColor(h/hottest))
+		}
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	return img
+}
@@ -0,0 +1,79 @@
+package types
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestOwnersRace records writes to the same pixels from two clients at once,
+// in the end they have to own every pixel together. The cells aren't
+// written, writes of pixels race on purpose. Run it with -race.
+func TestOwnersRace(t *testing.T) {
+	// the clients have to run at the same time, even on a single cpu
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	grid := NewGrid(4, 4, 0, 0)
+	b := grid.buf.Load()
+	registry := NewRegistry()
+	clients := []*Client{registry.Add("a", PROTOCOL_TCP, nil), registry.Add("b", PROTOCOL_TCP, nil)}
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			for i := 0; i < 200000; i++ {
+				grid.Owners.record(b, i%len(b.owners), client)
+			}
+		}(client)
+	}
+	wg.Wait()
+
+	var owned int64
+	for _, client := range clients {
+		owned += client.Owned[0].Load()
+	}
+	if owned != 16 {
+		t.Errorf("the clients own %d pixels, not 16", owned)
+	}
+	for _, owner := range grid.Lookup(grid.Bounds()) {
+		if owner.Client == nil {
+			t.Errorf("%d pixels have no owner", owner.Pixels)
+			continue
+		}
+		if int64(owner.Pixels) != owner.Client.Owned[0] {
+			t.Errorf("client %d owns %d pixels but counted %d", owner.Client.Id, owner.Pixels, owner.Client.Owned[0])
+		}
+	}
+}
+
+func TestOwnersServerTakesOver(t *testing.T) {
+	grid := NewGrid(2, 2, 0, 0)
+	client := NewRegistry().Add("a", PROTOCOL_TCP, nil)
+	for xy := uint32(0); xy < 2; xy++ {
+		grid.Set(xy, 0xffffffff, client)
+	}
+	grid.Set(0, 0xff000000, nil)
+	if owned := client.Owned[0].Load(); owned != 1 {
+		t.Errorf("the client owns %d pixels, not 1", owned)
+	}
+	grid.Set(1, 0xff000000, nil)
+	if _, found := grid.Owners.clients.Load(uint32(client.Id)); found {
+		t.Error("a client without pixels is still remembered")
+	}
+}
+
+func TestHeatmapIntensity(t *testing.T) {
+	grid := NewGrid(2, 1, 0, 0)
+	client := NewRegistry().Add("a", PROTOCOL_TCP, nil)
+	for i := 0; i < 10; i++ {
+		grid.Set(0, 0xffffffff, client)
+	}
+	grid.Set(1, 0xffffffff, client)
+	heatmap := grid.Heatmap(time.Minute, false)
+	hot, cold := heatmap.RGBAAt(0, 0), heatmap.RGBAAt(1, 0)
+	if cold.R == 0 || int(hot.R)+int(hot.G)+int(hot.B) <= int(cold.R)+int(cold.G)+int(cold.B) {
+		t.Errorf("a pixel written 10 times is %v, once is %v", hot, cold)
+	}
+}
@@ -0,0 +1,69 @@
+package types
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+const MAX_PALETTE_SIZE = 256
+
+var ErrPalette = errors.New("color is not in the palette")
+
+// Palette is the fixed set of colors of a canvas, writes with any other
+// color are snapped to the nearest one.
+type Palette struct {
+	colors atomic.Pointer[[]uint32]
+}
+
+func NewPalette() *Palette {
+	palette := &Palette{}
+	palette.colors.Store(&[]uint32{})
+	return palette
+}
+
+// Set replaces the colors of the palette, without colors every color is
+// allowed.
+func (p *Palette) Set(colors []uint32) error {
+	if len(colors) > MAX_PALETTE_SIZE {
+		return errors.New("a palette can't have more than 256 colors")
+	}
+	opaque := make([]uint32, len(colors))
+	for i, c := range colors {
+		opaque[i] = c | 0xff<<24
+	}
+	p.colors.Store(&opaque)
+	return nil
+}
+
+func (p *Palette) Colors() []uint32 {
+	return *p.colors.Load()
+}
+
+// Color returns the color at index i.
+func (p *Palette) Color(i int) (uint32, error) {
+	colors := p.Colors()
+	if i < 0 || i >= len(colors) {
+		return 0, ErrPalette
+	}
+	return colors[i], nil
+}
+
+// Nearest returns the color of the palette that is closest to c, or c itself
+// when there is no palette.
+func (p *Palette) Nearest(c uint32) uint32 {
+	colors := p.Colors()
+	if len(colors) == 0 {
+		return c
+	}
+	r, g, b, _ := Channels(c)
+	nearest, best := colors[0], -1
+	for _, color := range colors {
+		pr, pg, pb, _ := Channels(color)
+		dr, dg, db := int(r)-int(pr), int(g)-int(pg), int(b)-int(pb)
+		distance := dr*dr + dg*dg + db*db
+		if best < 0 || distance < best {
+			nearest, best = color, distance
+		}
+	}
+	return nearest
+}
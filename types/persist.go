@@ -0,0 +1,104 @@
+package types
+
+import (
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// saveImage writes img to path as a png. It writes to a temporary file
+// first, so a crash while saving doesn't leave a broken image behind.
+func saveImage(path string, img image.Image) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// LoadImage reads the png, jpeg or gif at path.
+func LoadImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+// cells is a grid without its layers, which are saved on their own.
+type cells struct {
+	*Grid
+}
+
+func (c cells) At(x, y int) color.Color {
+	b := c.buf.Load()
+	if x < 0 || y < 0 || x >= b.sizeX || y >= b.sizeY {
+		return color.RGBA{}
+	}
+	r, g, bl, _ := Channels(b.cells[y*b.sizeX+x])
+	return color.RGBA{r, g, bl, 0xff}
+}
+
+// Save writes the cells of the grid to path as a png.
+func (g *Grid) Save(path string) error {
+	return saveImage(path, cells{g})
+}
+
+// Load copies the image at path into the grid, the parts of the image that
+// don't fit are ignored.
+func (g *Grid) Load(path string) error {
+	img, err := LoadImage(path)
+	if err != nil {
+		return err
+	}
+	g.Draw(img, image.Point{})
+	return nil
+}
+
+// Save writes the layer to path as a png with its transparency.
+func (l *Layer) Save(path string) error {
+	return saveImage(path, l.Image())
+}
+
+// Load copies the image at path into the layer.
+func (l *Layer) Load(path string) error {
+	img, err := LoadImage(path)
+	if err != nil {
+		return err
+	}
+	l.Draw(img, image.Point{})
+	return nil
+}
+
+// Draw copies img onto the grid with its top left corner at offset. It is
+// not limited like the writes of clients are.
+func (g *Grid) Draw(img image.Image, offset image.Point) (count int) {
+	b := g.buf.Load()
+	bounds := img.Bounds()
+	area := bounds.Add(offset.Sub(bounds.Min)).Intersect(image.Rect(0, 0, b.sizeX, b.sizeY))
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			r, gr, bl, _ := img.At(x-offset.X+bounds.Min.X, y-offset.Y+bounds.Min.Y).RGBA()
+			b.setCell(y*b.sizeX+x, uint32(r>>8)|uint32(gr>>8)<<8|uint32(bl>>8)<<16|0xff<<24)
+			g.Owners.record(b, y*b.sizeX+x, nil)
+			count++
+		}
+	}
+	atomic.AddUint64(&g.ChangedPixels, uint64(count))
+	return
+}
@@ -0,0 +1,100 @@
+package types
+
+import (
+	"encoding/json"
+	"image"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Rect is a rectangle on a grid as it is used in files and the admin api.
+type Rect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+func (r Rect) Rectangle() image.Rectangle {
+	return image.Rect(r.X, r.Y, r.X+r.W, r.Y+r.H)
+}
+
+func (r Rect) Contains(x int, y int) bool {
+	return x >= r.X && x < r.X+r.W && y >= r.Y && y < r.Y+r.H
+}
+
+// list is a threadsafe list that is optimized for reading, since the lists
+// of rectangles are checked on every write to a grid.
+type list[T any] struct {
+	items atomic.Pointer[[]T]
+	lock  *sync.Mutex
+}
+
+func (l *list[T]) init() {
+	l.lock = &sync.Mutex{}
+	l.items.Store(&[]T{})
+}
+
+func (l *list[T]) List() []T {
+	return *l.items.Load()
+}
+
+func (l *list[T]) Add(items ...T) {
+	l.lock.Lock()
+	updated := append(slices.Clone(l.List()), items...)
+	l.items.Store(&updated)
+	l.lock.Unlock()
+}
+
+// Remove deletes the item at index i, it returns false if there is none.
+func (l *list[T]) Remove(i int) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	current := l.List()
+	if i < 0 || i >= len(current) {
+		return false
+	}
+	updated := slices.Delete(slices.Clone(current), i, i+1)
+	l.items.Store(&updated)
+	return true
+}
+
+// Regions is a threadsafe list of rectangles.
+type Regions struct {
+	list[Rect]
+}
+
+func NewRegions() *Regions {
+	regions := &Regions{}
+	regions.init()
+	return regions
+}
+
+// Contains reports whether the pixel (x, y) is inside any of the rectangles.
+func (r *Regions) Contains(x int, y int) bool {
+	for _, rect := range r.List() {
+		if rect.Contains(x, y) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanvasRect is a Rect that also says which canvas it belongs to.
+type CanvasRect struct {
+	Canvas byte `json:"canvas"`
+	Rect
+}
+
+// LoadCanvasRects reads a json file containing a list of CanvasRects.
+func LoadCanvasRects(path string) ([]CanvasRect, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rects := []CanvasRect{}
+	err = json.Unmarshal(data, &rects)
+	return rects, err
+}
@@ -0,0 +1,115 @@
+package types
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"os"
+	"sync/atomic"
+)
+
+// Zone is a part of a grid that only a single team can write to, zones
+// without a team are free for all.
+type Zone struct {
+	Team string `json:"team"`
+	Rect
+}
+
+// Zones is a threadsafe list of zones on a grid. When the zones are exclusive
+// nobody can write outside of them.
+type Zones struct {
+	list[Zone]
+	exclusive uint32
+}
+
+func NewZones() *Zones {
+	zones := &Zones{}
+	zones.init()
+	return zones
+}
+
+func (z *Zones) SetExclusive(exclusive bool) {
+	if exclusive {
+		atomic.StoreUint32(&z.exclusive, 1)
+	} else {
+		atomic.StoreUint32(&z.exclusive, 0)
+	}
+}
+
+func (z *Zones) Exclusive() bool {
+	return atomic.LoadUint32(&z.exclusive) == 1
+}
+
+// Allowed reports whether team can write to the pixel (x, y).
+func (z *Zones) Allowed(x int, y int, team string) bool {
+	zones := z.List()
+	if len(zones) == 0 {
+		return true
+	}
+	inside := false
+	for _, zone := range zones {
+		if !zone.Contains(x, y) {
+			continue
+		}
+		if zone.Team == "" || zone.Team == team {
+			return true
+		}
+		inside = true
+	}
+	return !inside && !z.Exclusive()
+}
+
+// Teams maps the tokens clients authenticate with to team names.
+type Teams struct {
+	tokens atomic.Pointer[map[string]string]
+}
+
+func NewTeams() *Teams {
+	teams := &Teams{}
+	teams.tokens.Store(&map[string]string{})
+	return teams
+}
+
+// Set replaces all teams, teams maps the team names to their tokens.
+func (t *Teams) Set(teams map[string]string) {
+	tokens := make(map[string]string, len(teams))
+	for team, token := range teams {
+		tokens[token] = team
+	}
+	t.tokens.Store(&tokens)
+}
+
+// Team returns the team that token belongs to.
+func (t *Teams) Team(token string) (string, bool) {
+	found := ""
+	for known, team := range *t.tokens.Load() {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			found = team
+		}
+	}
+	return found, found != ""
+}
+
+// CanvasZone is a Zone that also says which canvas it belongs to.
+type CanvasZone struct {
+	Canvas byte `json:"canvas"`
+	Zone
+}
+
+// TeamsFile is the format of the file the teams and their zones are loaded
+// from.
+type TeamsFile struct {
+	// Teams maps the team names to their tokens
+	Teams     map[string]string `json:"teams"`
+	Zones     []CanvasZone      `json:"zones"`
+	Exclusive bool              `json:"exclusive"`
+}
+
+func LoadTeamsFile(path string) (TeamsFile, error) {
+	file := TeamsFile{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return file, err
+	}
+	err = json.Unmarshal(data, &file)
+	return file, err
+}
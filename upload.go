@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/itepastra/flutties/helpers/access"
+	"github.com/itepastra/flutties/types"
+)
+
+const (
+	// MAX_UPLOAD_SIZE is the largest image file that can be uploaded
+	MAX_UPLOAD_SIZE = 8 << 20
+	// MAX_UPLOAD_DIMENSION is the largest width or height of an uploaded
+	// image, before and after scaling
+	MAX_UPLOAD_DIMENSION = 4096
+)
+
+// uploadImage reads the image of an upload, either the whole body or the
+// "image" field of a multipart form.
+func uploadImage(r *http.Request) (image.Image, error) {
+	var file io.Reader = r.Body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		part, _, err := r.FormFile("image")
+		if err != nil {
+			return nil, err
+		}
+		defer part.Close()
+		file = part
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	// check the size first, so small files can't decode into huge images
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width > MAX_UPLOAD_DIMENSION || config.Height > MAX_UPLOAD_DIMENSION {
+		return nil, fmt.Errorf("the image can't be larger than %dx%d", MAX_UPLOAD_DIMENSION, MAX_UPLOAD_DIMENSION)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// scaleImage resizes img to w by h, every pixel is the average of the pixels
+// of img it covers.
+func scaleImage(img image.Image, w int, h int) *image.NRGBA {
+	scaled := image.NewNRGBA(image.Rect(0, 0, w, h))
+	bounds := img.Bounds()
+	for y := 0; y < h; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/h
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/w
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/w, x0+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			scaled.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return scaled
+}
+
+// dither moves every pixel of img to a color of palette, spreading the
+// difference over the pixels next to it. The alpha of img is kept.
+func dither(img *image.NRGBA, palette []uint32) {
+	colors := make(color.Palette, len(palette))
+	for i, c := range palette {
+		r, g, b, _ := types.Channels(c)
+		colors[i] = color.NRGBA{r, g, b, 0xff}
+	}
+	dithered := image.NewPaletted(img.Bounds(), colors)
+	draw.FloydSteinberg.Draw(dithered, img.Bounds(), img, image.Point{})
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			c := colors[dithered.ColorIndexAt(x, y)].(color.NRGBA)
+			c.A = img.NRGBAAt(x, y).A
+			img.SetNRGBA(x, y, c)
+		}
+	}
+}
+
+func formInt(r *http.Request, key string, fallback int) (int, error) {
+	value := r.FormValue(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// uploadHandler draws an uploaded image on a canvas, with its top left corner
+// at x and y and scaled by scale. Images are dithered to the palette of the
+// canvas unless dither is false. Every pixel counts against the pixel limit
+// like those of pixelflut clients, so big images are only drawn partially.
+func uploadHandler(grids [types.GRID_AMOUNT]*types.Grid, webACL *access.List) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if banned(r.RemoteAddr) || !allowed(webACL, r.RemoteAddr) {
+			writeError(w, http.StatusForbidden, errors.New("forbidden"))
+			return
+		}
+		grid, err := canvasFromPath(r, grids)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_UPLOAD_SIZE)
+		img, err := uploadImage(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		x, err := formInt(r, "x", 0)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		y, err := formInt(r, "y", 0)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		scale := 1.0
+		if value := r.FormValue("scale"); value != "" {
+			scale, err = strconv.ParseFloat(value, 64)
+			if err != nil || scale <= 0 {
+				writeError(w, http.StatusBadRequest, errors.New("scale has to be a positive number"))
+				return
+			}
+		}
+		width := int(math.Round(float64(img.Bounds().Dx()) * scale))
+		height := int(math.Round(float64(img.Bounds().Dy()) * scale))
+		if width < 1 || height < 1 || width > MAX_UPLOAD_DIMENSION || height > MAX_UPLOAD_DIMENSION {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("the scaled image has to be between 1x1 and %dx%d", MAX_UPLOAD_DIMENSION, MAX_UPLOAD_DIMENSION))
+			return
+		}
+
+		scaled := scaleImage(img, width, height)
+		if palette := grid.Palette.Colors(); len(palette) > 0 && r.FormValue("dither") != "false" {
+			dither(scaled, palette)
+		}
+
+		client := clients.Host(r.RemoteAddr, types.PROTOCOL_HTTP)
+		sizeX, sizeY := grid.Size()
+		area := image.Rect(0, 0, sizeX, sizeY).Intersect(scaled.Rect.Add(image.Pt(x, y)))
+		placed := 0
+	pixels:
+		for py := area.Min.Y; py < area.Max.Y; py++ {
+			for px := area.Min.X; px < area.Max.X; px++ {
+				c := scaled.NRGBAAt(px-x, py-y)
+				if c.A == 0 {
+					continue
+				}
+				err := grid.Set(uint32(py)<<16|uint32(px), types.RGBA(c.R, c.G, c.B, c.A), client)
+				switch {
+				case err == nil:
+					placed++
+				case errors.Is(err, types.ErrFrozen), errors.Is(err, types.ErrRateLimited):
+					break pixels
+				}
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"pixels": placed})
+	}
+}